@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const includeFileHeader = "# Generated by gitid. Changes will be overwritten.\n"
+
+func (b Binding) condition() string {
+	return string(b.Kind) + ":" + b.Pattern
+}
+
+func (b Binding) key() string {
+	return "includeIf." + b.condition() + ".path"
+}
+
+// gitidConfigDir returns the directory gitid keeps generated files in.
+func gitidConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("error locating config directory: %w", err)
+	}
+	return filepath.Join(dir, "gitid"), nil
+}
+
+func includeFileDir() (string, error) {
+	dir, err := gitidConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identities"), nil
+}
+
+func includeFilePath(id string) (string, error) {
+	dir, err := includeFileDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, id+".gitconfig"), nil
+}
+
+// prepareIncludeFile returns the path of identity's include file, creating
+// the directory it lives in.
+func prepareIncludeFile(identity Identity) (string, error) {
+	path, err := includeFilePath(identity.ID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("error creating include directory: %w", err)
+	}
+	return path, nil
+}
+
+// fillIncludeFile replaces the content of f, an include file, with the
+// settings of identity.
+func fillIncludeFile(f *configFile, identity Identity) error {
+	fresh, err := parseConfigFile(f.path, includeFileHeader)
+	if err != nil {
+		return err
+	}
+	*f = *fresh
+	return applyIdentity(f, identity, ConfigTarget{Scope: ScopeFile, File: f.path})
+}
+
+// writeIncludeFile (re)generates the config fragment that bindings include
+// for identity and returns its path. The file is replaced under git's lock
+// in one rename, so git never reads it half-written.
+func writeIncludeFile(identity Identity) (string, error) {
+	path, err := prepareIncludeFile(identity)
+	if err != nil {
+		return "", err
+	}
+	if identity.SigningKey != "" {
+		if err := validateSigningKey(identity.SigningFormat, identity.SigningKey, identity.Email); err != nil {
+			return "", err
+		}
+	}
+
+	err = updateConfigFile(path, func(f *configFile) error {
+		return fillIncludeFile(f, identity)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error writing include file: %w", err)
+	}
+	return path, nil
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error expanding %s: %w", path, err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// normalizeGitdirPattern turns a directory into an absolute gitdir pattern
+// that matches every repository below it.
+func normalizeGitdirPattern(dir string) (string, error) {
+	dir, err := expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s: %w", dir, err)
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasSuffix(abs, "/") {
+		abs += "/"
+	}
+	return abs, nil
+}
+
+// listBindings returns the includeIf entries in the global config that
+// point at gitid's generated include files.
+func listBindings() ([]Binding, error) {
+	dir, err := includeFileDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _ := configRegexpAt(globalTarget, `^includeif\..*\.path$`)
+	var bindings []Binding
+
+	for _, entry := range entries {
+		key, path := entry.Key, entry.Value
+		if entry.Implicit || filepath.Dir(path) != dir {
+			continue
+		}
+
+		condition := strings.TrimSuffix(strings.TrimPrefix(key, "includeif."), ".path")
+		kind, pattern, found := parseCondition(condition)
+		if !found {
+			continue
+		}
+
+		bindings = append(bindings, Binding{
+			Kind:    kind,
+			Pattern: pattern,
+			Path:    path,
+		})
+	}
+	return bindings, nil
+}
+
+func parseCondition(condition string) (BindingKind, string, bool) {
+	for _, kind := range bindingKinds {
+		if pattern, found := strings.CutPrefix(condition, string(kind)+":"); found {
+			return kind, pattern, true
+		}
+	}
+	return "", "", false
+}
+
+// bindingApplies evaluates binding's condition against the repository in
+// the current directory the way git would.
+func bindingApplies(binding Binding) bool {
+	switch binding.Kind {
+	case BindGitdir:
+		out, err := exec.Command("git", "rev-parse", "--absolute-git-dir").Output()
+		if err != nil {
+			return false
+		}
+		gitDir := strings.TrimSpace(string(out))
+		if wildmatch(binding.Pattern, filepath.ToSlash(gitDir)) {
+			return true
+		}
+		resolved, err := filepath.EvalSymlinks(gitDir)
+		return err == nil && wildmatch(binding.Pattern, filepath.ToSlash(resolved))
+	case BindRemote:
+		out, _ := exec.Command("git", "config", "--null", "--get-regexp", `^remote\..*\.url$`).Output()
+		for _, entry := range strings.Split(string(out), "\x00") {
+			if _, url, found := strings.Cut(entry, "\n"); found && wildmatch(binding.Pattern, url) {
+				return true
+			}
+		}
+		return false
+	case BindBranch:
+		out, err := exec.Command("git", "symbolic-ref", "--quiet", "--short", "HEAD").Output()
+		return err == nil && wildmatch(binding.Pattern, strings.TrimSpace(string(out)))
+	}
+	return false
+}
+
+// explainOrigin finds the binding responsible for a value read from origin.
+// Only gitid include files have one.
+func explainOrigin(origin string) (Binding, bool) {
+	bindings, err := listBindings()
+	if err != nil {
+		return Binding{}, false
+	}
+	for _, binding := range bindings {
+		if binding.Path == origin && bindingApplies(binding) {
+			return binding, true
+		}
+	}
+	return Binding{}, false
+}
+
+// checkBindingSupport fails when the installed git cannot evaluate kind.
+func checkBindingSupport(kind BindingKind) error {
+	switch kind {
+	case BindRemote:
+		return requireGitVersion(2, 36, "remote bindings (includeIf \"hasconfig:remote.*.url:\")")
+	case BindBranch:
+		return requireGitVersion(2, 23, "branch bindings (includeIf \"onbranch:\")")
+	}
+	return nil
+}
+
+func findBinding(kind BindingKind, pattern string) (Binding, bool, error) {
+	bindings, err := listBindings()
+	if err != nil {
+		return Binding{}, false, err
+	}
+	for _, binding := range bindings {
+		if binding.Kind == kind && binding.Pattern == pattern {
+			return binding, true, nil
+		}
+	}
+	return Binding{}, false, nil
+}
+
+// includes reports whether f, the global config file, has a binding that
+// includes the file at path.
+func includes(f *configFile, path string) bool {
+	for _, entry := range f.entries() {
+		if strings.HasPrefix(entry.Key, "includeif.") && strings.HasSuffix(entry.Key, ".path") && !entry.Implicit && entry.Value == path {
+			return true
+		}
+	}
+	return false
+}
+
+func bindingsFor(id string) ([]Binding, error) {
+	path, err := includeFilePath(id)
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := listBindings()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []Binding
+	for _, binding := range bindings {
+		if binding.Path == path {
+			matched = append(matched, binding)
+		}
+	}
+	return matched, nil
+}
+
+// bindingIdentity resolves the catalog identity a binding's include file
+// was generated for. Include files are named after the identity's ID.
+func bindingIdentity(binding Binding) (Identity, bool) {
+	id := strings.TrimSuffix(filepath.Base(binding.Path), ".gitconfig")
+	identity, found, _ := gitConfigStore{}.Get(id)
+	return identity, found
+}
+
+func bindIdentity(identity Identity, kind BindingKind, pattern string) (Binding, error) {
+	if err := checkBindingSupport(kind); err != nil {
+		return Binding{}, err
+	}
+
+	existing, found, err := findBinding(kind, pattern)
+	if err != nil {
+		return Binding{}, err
+	}
+
+	path, err := writeIncludeFile(identity)
+	if err != nil {
+		return Binding{}, err
+	}
+
+	// Replacing a binding swaps the entries in one transaction, so the
+	// condition is never left unbound.
+	binding := Binding{Kind: kind, Pattern: pattern, Path: path}
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		if found {
+			if _, err := f.unsetValue(existing.key(), existing.Path); err != nil {
+				return err
+			}
+		}
+		return f.add(binding.key(), path)
+	})
+	if err != nil {
+		return Binding{}, fmt.Errorf("error adding binding: %w", err)
+	}
+	if found && existing.Path != path {
+		return binding, pruneIncludeFile(existing.Path)
+	}
+	return binding, nil
+}
+
+func unbindIdentity(kind BindingKind, pattern string) (Binding, error) {
+	binding, found, err := findBinding(kind, pattern)
+	if err != nil {
+		return Binding{}, err
+	}
+	if !found {
+		return Binding{}, fmt.Errorf("no binding for %s:%s", kind, pattern)
+	}
+	return binding, removeBinding(binding)
+}
+
+// removeBinding drops the includeIf entry for binding, leaving any entries
+// the user added for the same condition alone, and deletes the include
+// file once nothing references it.
+func removeBinding(binding Binding) error {
+	err := updateConfig(globalTarget, func(f *configFile) error {
+		_, err := f.unsetValue(binding.key(), binding.Path)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error removing binding: %w", err)
+	}
+	return pruneIncludeFile(binding.Path)
+}
+
+func pruneIncludeFile(path string) error {
+	bindings, err := listBindings()
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.Path == path {
+			return nil
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing include file: %w", err)
+	}
+	return nil
+}
+
+// moveBindings regenerates the include file for identity and repoints
+// every binding of the identity with oldID at it.
+func moveBindings(oldID string, identity Identity) error {
+	bindings, err := bindingsFor(oldID)
+	if err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		return nil
+	}
+
+	path, err := writeIncludeFile(identity)
+	if err != nil {
+		return err
+	}
+
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		for _, binding := range bindings {
+			if binding.Path == path {
+				continue
+			}
+			if err := f.replaceValue(binding.key(), binding.Path, path); err != nil {
+				return fmt.Errorf("error updating binding %s: %w", binding.condition(), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, binding := range bindings {
+		if binding.Path == path {
+			continue
+		}
+		if err := pruneIncludeFile(binding.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
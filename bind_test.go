@@ -0,0 +1,234 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func setupBoundRepo(t *testing.T) (workDir string) {
+	workDir = filepath.Join(os.Getenv("HOME"), "work")
+	repo := filepath.Join(workDir, "project")
+	if err := exec.Command("git", "init", "-q", repo).Run(); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return workDir
+}
+
+func gitCheckout(t *testing.T, branch string) {
+	t.Helper()
+	if err := exec.Command("git", "checkout", "-q", "-b", branch).Run(); err != nil {
+		t.Fatalf("git checkout -b %s failed: %v", branch, err)
+	}
+}
+
+func TestNormalizeGitdirPattern(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	home := filepath.ToSlash(os.Getenv("HOME"))
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"~/work", home + "/work/"},
+		{"~/work/", home + "/work/"},
+		{"/srv/repos", "/srv/repos/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			result, err := normalizeGitdirPattern(tt.input)
+			if err != nil {
+				t.Fatalf("normalizeGitdirPattern(%q) failed: %v", tt.input, err)
+			}
+			if result != tt.expected {
+				t.Errorf("normalizeGitdirPattern(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBindGitdir(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	workDir := setupBoundRepo(t)
+
+	addIdentity("Work User", "work@example.com", "work")
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+	identity, _ := findIdentityByIdentifier(t, "work")
+
+	pattern, err := normalizeGitdirPattern(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binding, err := bindIdentity(identity, BindGitdir, pattern)
+	if err != nil {
+		t.Fatalf("bindIdentity failed: %v", err)
+	}
+
+	current, err := getEffectiveIdentity()
+	if err != nil {
+		t.Fatalf("getEffectiveIdentity failed: %v", err)
+	}
+	if current.Email != "work@example.com" {
+		t.Errorf("effective email inside bound dir = %q, want work@example.com", current.Email)
+	}
+
+	bindings, err := listBindings()
+	if err != nil {
+		t.Fatalf("listBindings failed: %v", err)
+	}
+	if len(bindings) != 1 || bindings[0] != binding {
+		t.Errorf("listBindings() = %+v, want [%+v]", bindings, binding)
+	}
+
+	if err := deleteIdentity(identity.ID); err == nil {
+		t.Error("deleteIdentity should refuse to delete a bound identity")
+	}
+
+	if _, err := unbindIdentity(BindGitdir, pattern); err != nil {
+		t.Fatalf("unbindIdentity failed: %v", err)
+	}
+	if _, err := os.Stat(binding.Path); !os.IsNotExist(err) {
+		t.Error("include file should be removed once nothing is bound to it")
+	}
+
+	current, _ = getEffectiveIdentity()
+	if current.Email != "me@example.com" {
+		t.Errorf("effective email after unbind = %q, want me@example.com", current.Email)
+	}
+}
+
+func TestUpdateIdentityMovesBindings(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	workDir := setupBoundRepo(t)
+
+	addIdentity("Work User", "old@example.com", "work")
+	identity, _ := findIdentityByIdentifier(t, "work")
+	pattern, _ := normalizeGitdirPattern(workDir)
+	if _, err := bindIdentity(identity, BindGitdir, pattern); err != nil {
+		t.Fatalf("bindIdentity failed: %v", err)
+	}
+
+	if err := updateIdentity(identity.ID, "Work User", "new@example.com", "work"); err != nil {
+		t.Fatalf("updateIdentity failed: %v", err)
+	}
+
+	bindings, _ := bindingsFor(identity.ID)
+	if len(bindings) != 1 || bindings[0].Pattern != pattern {
+		t.Errorf("bindingsFor(%s) = %+v, want one binding for %s", identity.ID, bindings, pattern)
+	}
+	if updated, _ := findIdentityByIdentifier(t, "work"); updated.ID != identity.ID {
+		t.Errorf("updateIdentity changed the ID from %s to %s", identity.ID, updated.ID)
+	}
+
+	current, _ := getEffectiveIdentity()
+	if current.Email != "new@example.com" {
+		t.Errorf("effective email after update = %q, want new@example.com", current.Email)
+	}
+}
+
+func TestBindRemote(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	if err := checkBindingSupport(BindRemote); err != nil {
+		t.Skipf("installed git lacks hasconfig support: %v", err)
+	}
+
+	addIdentity("Acme Dev", "dev@acme.com", "acme")
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+	exec.Command("git", "remote", "add", "origin", "git@github.com:acme/widgets.git").Run()
+
+	identity, _ := findIdentityByIdentifier(t, "acme")
+	if _, err := bindIdentity(identity, BindRemote, "git@github.com:acme/**"); err != nil {
+		t.Fatalf("bindIdentity failed: %v", err)
+	}
+
+	current, _ := getEffectiveIdentity()
+	if current.Email != "dev@acme.com" {
+		t.Errorf("effective email with matching remote = %q, want dev@acme.com", current.Email)
+	}
+
+	binding, found, err := findBinding(BindRemote, "git@github.com:acme/**")
+	if err != nil || !found {
+		t.Fatalf("findBinding(remote) = %+v, %v, %v", binding, found, err)
+	}
+
+	exec.Command("git", "remote", "set-url", "origin", "git@github.com:other/widgets.git").Run()
+	current, _ = getEffectiveIdentity()
+	if current.Email != "me@example.com" {
+		t.Errorf("effective email with other remote = %q, want me@example.com", current.Email)
+	}
+}
+
+func TestBindBranchExplainsCurrent(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	if err := checkBindingSupport(BindBranch); err != nil {
+		t.Skipf("installed git lacks onbranch support: %v", err)
+	}
+
+	addIdentity("OSS Handle", "oss@example.org", "oss")
+	switchIdentity(Identity{Name: "Internal", Email: "me@corp.example"}, globalTarget)
+	identity, _ := findIdentityByIdentifier(t, "oss")
+	if _, err := bindIdentity(identity, BindBranch, "upstream/*"); err != nil {
+		t.Fatalf("bindIdentity failed: %v", err)
+	}
+
+	current, _ := getEffectiveIdentity()
+	if current.Email != "me@corp.example" || current.Binding.Kind != "" {
+		t.Errorf("effective identity on default branch = %+v, want unbound me@corp.example", current)
+	}
+
+	gitCheckout(t, "upstream/main")
+	current, _ = getEffectiveIdentity()
+	if current.Email != "oss@example.org" {
+		t.Errorf("effective email on upstream/main = %q, want oss@example.org", current.Email)
+	}
+	if current.Binding.Kind != BindBranch || current.Binding.Pattern != "upstream/*" {
+		t.Errorf("effective identity binding = %+v, want onbranch:upstream/*", current.Binding)
+	}
+}
+
+func TestWildmatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		input   string
+		match   bool
+	}{
+		{"upstream/*", "upstream/main", true},
+		{"upstream/*", "upstream/release/1.0", false},
+		{"upstream/", "upstream/release/1.0", true},
+		{"git@github.com:acme/**", "git@github.com:acme/widgets.git", true},
+		{"git@github.com:acme/**", "git@github.com:other/widgets.git", false},
+		{"https://**/acme/*", "https://gitlab.example.com/group/acme/repo", true},
+		{"/home/u/work/", "/home/u/work/project/.git", true},
+		{"/home/u/work/", "/home/u/workshop/.git", false},
+		{"feat-?", "feat-1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.input, func(t *testing.T) {
+			if got := wildmatch(tt.pattern, tt.input); got != tt.match {
+				t.Errorf("wildmatch(%q, %q) = %v, want %v", tt.pattern, tt.input, got, tt.match)
+			}
+		})
+	}
+}
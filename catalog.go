@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// exportCatalog renders every identity as TOML. Key paths under the home
+// directory are written as ~/... so the catalog works on another machine;
+// with redact they are left out entirely.
+func exportCatalog(store IdentityStore, redact bool) (string, error) {
+	stored, err := store.List()
+	if err != nil {
+		return "", err
+	}
+
+	var identities []Identity
+	for _, identity := range stored {
+		if redact {
+			identity.SSHKey = ""
+			if identity.SigningFormat == SigningSSH && !strings.HasPrefix(identity.SigningKey, "key::") {
+				identity.SigningFormat = ""
+				identity.SigningKey = ""
+			}
+		} else {
+			identity.SSHKey = portablePath(identity.SSHKey)
+			if identity.SigningFormat == SigningSSH {
+				identity.SigningKey = portablePath(identity.SigningKey)
+			}
+		}
+		identities = append(identities, identity)
+	}
+	return encodeCatalog(identities), nil
+}
+
+// portablePath rewrites a path below the home directory as ~/...
+func portablePath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || path == "" {
+		return path
+	}
+	if rel, err := filepath.Rel(home, path); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") && filepath.IsAbs(path) {
+		return "~/" + filepath.ToSlash(rel)
+	}
+	return path
+}
+
+func parseImportStrategy(value string) (ImportStrategy, error) {
+	switch strategy := ImportStrategy(value); strategy {
+	case "":
+		return ImportSkip, nil
+	case ImportSkip, ImportOverwrite, ImportRename:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown conflict strategy: %s\nSupported strategies: skip, overwrite, rename", value)
+	}
+}
+
+// planImport decides what importing incoming into existing does. An
+// incoming identity is the stored one with the same name and email; it
+// conflicts when that one has different attributes, when another stored
+// identity has its email, or when its nickname belongs to another
+// identity. Earlier entries of incoming count as existing for later ones.
+func planImport(existing, incoming []Identity, strategy ImportStrategy) []ImportAction {
+	working := append([]Identity{}, existing...)
+	find := func(match func(Identity) bool) int {
+		for i, identity := range working {
+			if match(identity) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var actions []ImportAction
+	for _, identity := range incoming {
+		same := func(other Identity) bool {
+			return other.Email == identity.Email && other.Name == identity.Name
+		}
+		stored := find(same)
+		emailOwner := -1
+		unchanged := false
+		if stored >= 0 {
+			identity.ID = working[stored].ID
+			unchanged = working[stored] == identity
+		} else {
+			emailOwner = find(func(other Identity) bool { return other.Email == identity.Email })
+		}
+		nickOwner := -1
+		if identity.Nickname != "" {
+			nickOwner = find(func(other Identity) bool {
+				return other.Nickname == identity.Nickname && !same(other)
+			})
+		}
+
+		action := ImportAction{Kind: "add", Identity: identity}
+		switch {
+		case unchanged && nickOwner < 0:
+			action.Kind = "unchanged"
+		case stored < 0 && emailOwner < 0 && nickOwner < 0:
+		case strategy == ImportOverwrite:
+			action.Kind = "overwrite"
+			if stored < 0 && emailOwner >= 0 {
+				// Replace the identity with this email, keeping its ID.
+				stored = emailOwner
+				action.Identity.ID = working[stored].ID
+				action.Reason = "replaces " + getIdentityDisplay(working[stored])
+			}
+			if nickOwner >= 0 && nickOwner != stored {
+				action.TakeNickname = working[nickOwner].ID
+				action.Reason = joinReasons(action.Reason, "takes nickname from "+working[nickOwner].Email)
+				working[nickOwner].Nickname = ""
+			}
+			if stored < 0 {
+				action.Kind = "add"
+			}
+		case strategy == ImportRename && stored < 0:
+			if emailOwner >= 0 {
+				action.Reason = "email also used by " + getIdentityDisplay(working[emailOwner])
+			}
+			if nickOwner >= 0 {
+				nickname := identity.Nickname
+				for n := 2; find(func(other Identity) bool { return other.Nickname == nickname }) >= 0; n++ {
+					nickname = identity.Nickname + "-" + strconv.Itoa(n)
+				}
+				action.Kind = "rename"
+				action.Reason = joinReasons(action.Reason, fmt.Sprintf("nickname %s is taken by %s", identity.Nickname, working[nickOwner].Email))
+				action.Identity.Nickname = nickname
+			}
+		default:
+			action.Kind = "skip"
+			switch {
+			case stored >= 0 && !unchanged:
+				action.Reason = "already stored with different settings"
+			case emailOwner >= 0:
+				action.Reason = "email is used by " + getIdentityDisplay(working[emailOwner])
+			default:
+				action.Reason = fmt.Sprintf("nickname %s is taken by %s", identity.Nickname, working[nickOwner].Email)
+			}
+		}
+
+		switch action.Kind {
+		case "add", "rename":
+			working = append(working, action.Identity)
+		case "overwrite":
+			working[stored] = action.Identity
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func joinReasons(reason, more string) string {
+	if reason == "" {
+		return more
+	}
+	return reason + "; " + more
+}
+
+// validateCatalogIdentity checks an incoming identity the way add and
+// signing do, short of looking for the keys on disk: they are often
+// restored after the catalog and are checked on the next switch.
+func validateCatalogIdentity(identity Identity) error {
+	if err := validateIdentity(identity); err != nil {
+		return err
+	}
+	switch identity.SigningFormat {
+	case "":
+		if identity.SigningKey != "" {
+			return validationErrorf("signing key %s has no signing format", identity.SigningKey)
+		}
+	case SigningSSH, SigningOpenPGP, SigningX509:
+		if identity.SigningKey == "" {
+			return validationErrorf("signing format %s has no signing key", identity.SigningFormat)
+		}
+		if identity.SigningFormat != SigningSSH {
+			if _, ok := normalizeKeyID(identity.SigningKey); !ok {
+				return validationErrorf("invalid key ID %s: use the 16-digit long key ID or the full fingerprint", identity.SigningKey)
+			}
+		}
+	default:
+		return validationErrorf("unsupported signing format: %s", identity.SigningFormat)
+	}
+	if strings.ContainsAny(identity.SigningKey+identity.SSHKey, "\n") {
+		return validationErrorf("key settings cannot contain newlines")
+	}
+	return nil
+}
+
+// applyImport carries out the add, rename and overwrite steps of a plan.
+// Each identity is validated again before it is stored. A failure after the first identity was stored is reported
+// as a partial write.
+func applyImport(store IdentityStore, actions []ImportAction) error {
+	imported := 0
+	for _, action := range actions {
+		switch action.Kind {
+		case "add", "rename", "overwrite":
+		default:
+			continue
+		}
+
+		err := validateCatalogIdentity(action.Identity)
+		if err == nil {
+			err = importIdentity(store, action)
+		}
+		if err != nil {
+			err = fmt.Errorf("error importing %s: %w", action.Identity.Email, err)
+			if imported > 0 {
+				err = &partialWriteError{done: fmt.Sprintf("%d identities imported", imported), err: err}
+			}
+			return err
+		}
+		imported++
+	}
+	return nil
+}
+
+func importIdentity(store IdentityStore, action ImportAction) error {
+	if action.TakeNickname != "" {
+		previous, found, err := store.Get(action.TakeNickname)
+		if err != nil {
+			return err
+		}
+		if found {
+			previous.Nickname = ""
+			if err := store.Put(previous); err != nil {
+				return err
+			}
+		}
+	}
+	return store.Put(action.Identity)
+}
+
+// importCatalog reads a catalog from path ("-" for stdin) and merges it
+// into the stored identities. With dryRun the plan is only returned.
+func importCatalog(store IdentityStore, path string, strategy ImportStrategy, dryRun bool) ([]ImportAction, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading catalog: %w", err)
+	}
+
+	incoming, err := decodeCatalog(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for i := range incoming {
+		if err := validateCatalogIdentity(incoming[i]); err != nil {
+			return nil, fmt.Errorf("error in identity %d of %s: %w", i+1, path, err)
+		}
+		for _, key := range []*string{&incoming[i].SSHKey, &incoming[i].SigningKey} {
+			if *key, err = expandHome(*key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	existing, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	actions := planImport(existing, incoming, strategy)
+	if dryRun {
+		return actions, nil
+	}
+	return actions, applyImport(store, actions)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanImport(t *testing.T) {
+	work := Identity{ID: "0123456789ab", Name: "Work User", Email: "work@example.com", Nickname: "work"}
+	existing := []Identity{work}
+	incomingWork := work
+	incomingWork.ID = ""
+	changedWork := incomingWork
+	changedWork.SSHKey = "~/.ssh/id_work"
+
+	tests := []struct {
+		name     string
+		incoming Identity
+		strategy ImportStrategy
+		kind     string
+		nickname string
+		take     string
+	}{
+		{"new identity", Identity{Name: "OSS", Email: "oss@example.com", Nickname: "oss"}, ImportSkip, "add", "oss", ""},
+		{"identical", incomingWork, ImportSkip, "unchanged", "work", ""},
+		{"same email other name skip", Identity{Name: "Work Bot", Email: work.Email}, ImportSkip, "skip", "", ""},
+		{"same email other name rename", Identity{Name: "Work Bot", Email: work.Email}, ImportRename, "add", "", ""},
+		{"same email other name overwrite", Identity{Name: "Work Bot", Email: work.Email, Nickname: "work"}, ImportOverwrite, "overwrite", "work", ""},
+		{"changed skip", changedWork, ImportSkip, "skip", "work", ""},
+		{"changed overwrite", changedWork, ImportOverwrite, "overwrite", "work", ""},
+		{"changed rename", changedWork, ImportRename, "skip", "work", ""},
+		{"nickname taken skip", Identity{Name: "Other", Email: "other@example.com", Nickname: "work"}, ImportSkip, "skip", "work", ""},
+		{"nickname taken rename", Identity{Name: "Other", Email: "other@example.com", Nickname: "work"}, ImportRename, "rename", "work-2", ""},
+		{"nickname taken overwrite", Identity{Name: "Other", Email: "other@example.com", Nickname: "work"}, ImportOverwrite, "add", "work", work.ID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actions := planImport(existing, []Identity{tt.incoming}, tt.strategy)
+			if len(actions) != 1 {
+				t.Fatalf("planImport returned %d actions, want 1", len(actions))
+			}
+			action := actions[0]
+			if action.Kind != tt.kind || action.Identity.Nickname != tt.nickname || action.TakeNickname != tt.take {
+				t.Errorf("planImport = %+v, want kind %s, nickname %q, take %q", action, tt.kind, tt.nickname, tt.take)
+			}
+		})
+	}
+
+	// Entries earlier in the same catalog count as taken.
+	incoming := []Identity{
+		{Name: "A", Email: "a@example.com", Nickname: "work"},
+		{Name: "B", Email: "b@example.com", Nickname: "work"},
+	}
+	actions := planImport(existing, incoming, ImportRename)
+	if actions[0].Identity.Nickname != "work-2" || actions[1].Identity.Nickname != "work-3" {
+		t.Errorf("planImport renamed to %q and %q, want work-2 and work-3", actions[0].Identity.Nickname, actions[1].Identity.Nickname)
+	}
+	// Overwriting by email keeps the ID of the identity it replaces.
+	actions = planImport(existing, []Identity{{Name: "Work Bot", Email: work.Email}}, ImportOverwrite)
+	if actions[0].Identity.ID != work.ID {
+		t.Errorf("overwrite by email has ID %q, want %q", actions[0].Identity.ID, work.ID)
+	}
+}
+
+func TestImportRejectsInvalidIdentities(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+	}{
+		{"invalid email", "[[identity]]\nname = \"Work User\"\nemail = \"not an email\"\n"},
+		{"bracket in name", "[[identity]]\nname = \"Work <User>\"\nemail = \"work@example.com\"\n"},
+		{"unknown signing format", "[[identity]]\nname = \"Work User\"\nemail = \"work@example.com\"\nsigning_format = \"pgp\"\nsigning_key = \"612155993F197D83\"\n"},
+		{"signing key without format", "[[identity]]\nname = \"Work User\"\nemail = \"work@example.com\"\nsigning_key = \"612155993F197D83\"\n"},
+		{"short key id", "[[identity]]\nname = \"Work User\"\nemail = \"work@example.com\"\nsigning_format = \"openpgp\"\nsigning_key = \"3F197D83\"\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newMemoryStore()
+			path := filepath.Join(t.TempDir(), "identities.toml")
+			os.WriteFile(path, []byte("[[identity]]\nname = \"OSS User\"\nemail = \"oss@example.com\"\n\n"+tt.catalog), 0o600)
+
+			_, err := importCatalog(store, path, ImportSkip, false)
+			if exitStatus(err) != ExitInvalid {
+				t.Errorf("importCatalog error = %v, want a validation error", err)
+			}
+			if identities, _ := store.List(); len(identities) != 0 {
+				t.Errorf("an invalid catalog should import nothing, got %+v", identities)
+			}
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	home := os.Getenv("HOME")
+
+	sshKey := filepath.Join(home, ".ssh", "id_work")
+	if err := addIdentity("Work User", "work@example.com", "work"); err != nil {
+		t.Fatalf("addIdentity failed: %v", err)
+	}
+	if err := addIdentity("OSS User", "oss@example.com", ""); err != nil {
+		t.Fatalf("addIdentity failed: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(sshKey), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sshKey, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store := gitConfigStore{}
+	work, _ := findIdentityByIdentifier(t, "work")
+	work, err := withSSHKey(work, sshKey)
+	if err != nil {
+		t.Fatalf("withSSHKey failed: %v", err)
+	}
+	if err := store.Put(work); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	catalog, err := exportCatalog(store, false)
+	if err != nil {
+		t.Fatalf("exportCatalog failed: %v", err)
+	}
+	if !strings.Contains(catalog, `ssh_key = "~/.ssh/id_work"`) {
+		t.Errorf("export should write key paths relative to home:\n%s", catalog)
+	}
+	if redacted, _ := exportCatalog(store, true); strings.Contains(redacted, "ssh_key") {
+		t.Errorf("redacted export should omit key paths:\n%s", redacted)
+	}
+
+	path := filepath.Join(t.TempDir(), "identities.toml")
+	if err := os.WriteFile(path, []byte(catalog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteIdentity(work.ID); err != nil {
+		t.Fatalf("deleteIdentity failed: %v", err)
+	}
+	oss, _ := findIdentityByIdentifier(t, "oss@example.com")
+	oss.Nickname = "work"
+	if err := updateInStore(store, oss); err != nil {
+		t.Fatalf("updateInStore failed: %v", err)
+	}
+
+	actions, err := importCatalog(store, path, ImportRename, true)
+	if err != nil {
+		t.Fatalf("importCatalog dry run failed: %v", err)
+	}
+	if len(actions) != 2 || actions[0].Kind != "rename" || actions[1].Kind != "skip" {
+		t.Fatalf("import plan = %+v, want rename then skip", actions)
+	}
+	if _, found := findIdentityByIdentifier(t, "work@example.com"); found {
+		t.Fatal("dry run should not import anything")
+	}
+
+	if _, err := importCatalog(store, path, ImportRename, false); err != nil {
+		t.Fatalf("importCatalog failed: %v", err)
+	}
+	imported, found := findIdentityByIdentifier(t, "work-2")
+	if !found {
+		t.Fatal("imported identity should be renamed to work-2")
+	}
+	if imported.Email != "work@example.com" || imported.SSHKey != sshKey {
+		t.Errorf("imported identity = %+v, want work@example.com with key %s", imported, sshKey)
+	}
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/posener/complete/v2"
@@ -12,78 +12,224 @@ import (
 	"github.com/posener/complete/v2/predict"
 )
 
+// predictIdentities suggests the identifiers of every identity, those of
+// the best matches for prefix first.
 func predictIdentities(prefix string) []string {
-	identities := getAllIdentities()
-	var suggestions []string
+	identities, err := getAllIdentities()
+	if err != nil {
+		return nil
+	}
 
-	for _, identity := range identities {
-		if identity.Nickname != "" {
-			suggestions = append(suggestions, identity.Nickname)
+	var suggestions []string
+	for _, candidate := range rankIdentities(identities, prefix) {
+		if candidate.Nickname != "" {
+			suggestions = append(suggestions, candidate.Nickname)
 		}
-		suggestions = append(suggestions, identity.Name)
-		suggestions = append(suggestions, identity.Email)
+		suggestions = append(suggestions, candidate.Name)
+		suggestions = append(suggestions, candidate.Email)
 	}
 
 	return suggestions
 }
 
+var outputFlags = map[string]complete.Predictor{
+	"json":   predict.Nothing,
+	"format": predict.Set{"short", "full", "author"},
+}
+
+var scopeFlags = map[string]complete.Predictor{
+	"global":   predict.Nothing,
+	"local":    predict.Nothing,
+	"worktree": predict.Nothing,
+	"file":     predict.Files("*"),
+}
+
+var addFlags = map[string]complete.Predictor{
+	"signing-key": predict.Files("*.pub"),
+	"gpg-key":     predict.Something,
+	"x509-key":    predict.Something,
+	"ssh-key":     predict.Files("*"),
+}
+
+var signingFlags = map[string]complete.Predictor{
+	"ssh":    predict.Files("*.pub"),
+	"gpg":    predict.Something,
+	"x509":   predict.Something,
+	"remove": predict.Nothing,
+}
+
+var bindFlags = map[string]complete.Predictor{
+	"list":   predict.Nothing,
+	"remote": predict.Something,
+	"branch": predict.Something,
+}
+
+var importFlags = map[string]complete.Predictor{
+	"dry-run":     predict.Nothing,
+	"on-conflict": predict.Set{"skip", "overwrite", "rename"},
+}
+
+var hookCommand = &complete.Command{
+	Sub: map[string]*complete.Command{
+		"install":   {Flags: map[string]complete.Predictor{"global": predict.Nothing, "expect": complete.PredictFunc(predictIdentities)}},
+		"uninstall": {Flags: map[string]complete.Predictor{"global": predict.Nothing}},
+		"status":    {},
+	},
+}
+
 func setupCompletion() {
 	cmd := &complete.Command{
 		Sub: map[string]*complete.Command{
-			"list":       {},
-			"current":    {},
-			"switch":     {Args: complete.PredictFunc(predictIdentities)},
-			"use":        {Args: complete.PredictFunc(predictIdentities)},
-			"add":        {},
+			"list":       {Flags: outputFlags},
+			"current":    {Flags: outputFlags},
+			"show":       {Args: complete.PredictFunc(predictIdentities), Flags: outputFlags},
+			"switch":     {Args: complete.PredictFunc(predictIdentities), Flags: scopeFlags},
+			"use":        {Args: complete.PredictFunc(predictIdentities), Flags: scopeFlags},
+			"find":       {Args: complete.PredictFunc(predictIdentities)},
+			"tag":        {Args: complete.PredictFunc(predictIdentities), Flags: map[string]complete.Predictor{"remove": predict.Nothing}},
+			"unpin":      {Flags: scopeFlags},
+			"add":        {Flags: addFlags},
 			"delete":     {Args: complete.PredictFunc(predictIdentities)},
 			"nickname":   {Args: complete.PredictFunc(predictIdentities)},
+			"signing":    {Args: predict.Or(complete.PredictFunc(predictIdentities), predict.Files("*.pub")), Flags: signingFlags},
+			"sshkey":     {Args: predict.Or(complete.PredictFunc(predictIdentities), predict.Files("*")), Flags: map[string]complete.Predictor{"remove": predict.Nothing}},
+			"keys":       {Args: complete.PredictFunc(predictIdentities), Flags: map[string]complete.Predictor{"x509": predict.Nothing}},
+			"bind":       {Args: predict.Or(predict.Dirs("*"), complete.PredictFunc(predictIdentities)), Flags: bindFlags},
+			"unbind":     {Args: predict.Dirs("*"), Flags: bindFlags},
+			"hook":       hookCommand,
+			"exec":       {Args: complete.PredictFunc(predictIdentities)},
+			"shell":      {Args: complete.PredictFunc(predictIdentities)},
+			"export":     {Args: predict.Files("*.toml"), Flags: map[string]complete.Predictor{"redact": predict.Nothing}},
+			"import":     {Args: predict.Files("*.toml"), Flags: importFlags},
+			"migrate":    {Flags: map[string]complete.Predictor{"dry-run": predict.Nothing}},
+			"prompt":     {Flags: map[string]complete.Predictor{"format": predict.Something}},
+			"env":        {Args: complete.PredictFunc(predictIdentities), Flags: map[string]complete.Predictor{"format": predict.Set{"sh", "fish", "docker"}}},
 			"completion": {Args: predict.Set{"bash", "zsh", "fish"}, Flags: map[string]complete.Predictor{"r": predict.Nothing}},
 			"help":       {},
 		},
 		Flags: map[string]complete.Predictor{
-			"h":    predict.Nothing,
-			"help": predict.Nothing,
+			"h":       predict.Nothing,
+			"help":    predict.Nothing,
+			"dry-run": predict.Nothing,
+			"exact":   predict.Nothing,
 		},
 	}
 
 	cmd.Complete("gitid")
 }
 
-func handleCLICommand(args []string) error {
+func handleCLICommand(store IdentityStore, args []string) error {
+	exact, args := extractExactFlag(args)
+	if _, wrapped := store.(exactStore); exact && !wrapped {
+		store = exactStore{store}
+	}
 	if len(args) == 0 {
 		return fmt.Errorf("no command provided")
 	}
 
 	command := args[0]
 	switch command {
-	case "list":
-		return listIdentitiesCLI()
-	case "current":
-		return getCurrentIdentityCLI()
+	case "--dry-run":
+		return dryRunCLI(store, args[1:])
+	case "list", "current", "show":
+		asJSON, _ := extractFlag(args[1:], "--json")
+		return withJSONErrors(asJSON, readCommandCLI(store, command, args[1:]))
 	case "switch", "use":
-		if len(args) < 2 {
-			return fmt.Errorf("usage: gitid %s <identifier>", command)
+		target, rest, err := parseScopeFlags(args[1:], globalTarget)
+		if err != nil {
+			return err
+		}
+		if len(rest) > 1 || len(rest) == 0 && !isInteractive() {
+			return fmt.Errorf("usage: gitid %s [--global|--local|--worktree|--file <path>] <identifier>", command)
+		}
+		if len(rest) == 0 {
+			return pickSwitchCLI(store, target)
 		}
-		return switchIdentityCLI(args[1])
+		return switchIdentityCLI(store, rest[0], target)
+	case "unpin":
+		target, rest, err := parseScopeFlags(args[1:], ConfigTarget{Scope: ScopeLocal})
+		if err != nil {
+			return err
+		}
+		if len(rest) > 0 {
+			return fmt.Errorf("usage: gitid unpin [--local|--worktree|--file <path>]")
+		}
+		return unpinCLI(target)
 	case "add":
-		if len(args) < 3 {
-			return fmt.Errorf("usage: gitid add <name> <email> [nickname]")
+		format, signingKey, rest, err := parseSigningFlags(args[1:])
+		if err != nil {
+			return err
 		}
-		nickname := ""
-		if len(args) > 3 {
-			nickname = args[3]
+		sshKey, rest, err := extractFlagValue(rest, "--ssh-key")
+		if err != nil {
+			return err
 		}
-		return addIdentityCLI(args[1], args[2], nickname)
+		if len(rest) < 2 {
+			return fmt.Errorf("usage: gitid add <name> <email> [nickname] [--signing-key <key>|--gpg-key <fpr>|--x509-key <id>] [--ssh-key <path>]")
+		}
+		identity := Identity{
+			Name:          rest[0],
+			Email:         rest[1],
+			SigningFormat: format,
+			SigningKey:    signingKey,
+			SSHKey:        sshKey,
+		}
+		if len(rest) > 2 {
+			identity.Nickname = rest[2]
+		}
+		return addIdentityCLI(store, identity)
+	case "find":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitid find <query>")
+		}
+		return findCLI(store, args[1])
+	case "tag":
+		return tagCLI(store, args[1:])
 	case "delete":
 		if len(args) < 2 {
 			return fmt.Errorf("usage: gitid delete <identifier>")
 		}
-		return deleteIdentityCLI(args[1])
+		return deleteIdentityCLI(store, args[1])
 	case "nickname":
 		if len(args) < 3 {
 			return fmt.Errorf("usage: gitid nickname <identifier> <nickname>")
 		}
-		return setNicknameCLI(args[1], args[2])
+		return setNicknameCLI(store, args[1], args[2])
+	case "signing":
+		if len(args) < 3 {
+			return fmt.Errorf(signingUsage)
+		}
+		return setSigningKeyCLI(store, args[1], args[2:])
+	case "sshkey":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: gitid sshkey <identifier> <private-key>\n       gitid sshkey <identifier> --remove")
+		}
+		return setSSHKeyCLI(store, args[1], args[2])
+	case "keys":
+		return listKeysCLI(store, args[1:])
+	case "bind":
+		return bindCLI(store, args[1:])
+	case "unbind":
+		return unbindCLI(args[1:])
+	case "hook":
+		return hookCLI(store, args[1:])
+	case "exec":
+		return execCLI(store, args[1:])
+	case "env":
+		return envCLI(store, args[1:])
+	case "shell":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: gitid shell <identifier>")
+		}
+		return shellCLI(store, args[1])
+	case "export":
+		return exportCLI(store, args[1:])
+	case "import":
+		return importCLI(store, args[1:])
+	case "prompt":
+		return promptCLI(args[1:])
+	case "migrate":
+		return migrateCLI(args[1:])
 	case "completion":
 		return completionCLI(args[1:])
 	case "help", "--help", "-h":
@@ -94,8 +240,94 @@ func handleCLICommand(args []string) error {
 	}
 }
 
-func listIdentitiesCLI() error {
-	identities := getAllIdentities()
+// dryRunCommands are the commands that only touch the identity store and
+// can therefore run against an in-memory copy of it.
+var dryRunCommands = []string{"add", "delete", "nickname", "tag", "switch", "use", "signing", "sshkey", "import"}
+
+// dryRunCLI runs a command against an in-memory copy of store, so it
+// reports what it would do without writing any config.
+func dryRunCLI(store IdentityStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: gitid --dry-run <command> [args...]")
+	}
+	supported := false
+	for _, command := range dryRunCommands {
+		supported = supported || command == args[0]
+	}
+	if !supported {
+		return fmt.Errorf("%s does not support --dry-run\nSupported commands: %s", args[0], strings.Join(dryRunCommands, ", "))
+	}
+
+	memory, err := snapshotStore(store)
+	if err != nil {
+		return err
+	}
+	var target IdentityStore = memory
+	if _, exact := store.(exactStore); exact {
+		target = exactStore{memory}
+	}
+	if err := handleCLICommand(target, args); err != nil {
+		return err
+	}
+	fmt.Println("Dry run: no changes were made.")
+	return nil
+}
+
+func readCommandCLI(store IdentityStore, command string, args []string) error {
+	asJSON, args := extractFlag(args, "--json")
+	format, args, err := extractFlagValue(args, "--format")
+	if err != nil {
+		return err
+	}
+	output := OutputOptions{JSON: asJSON}
+	if format != "" {
+		if asJSON {
+			return fmt.Errorf("--json and --format cannot be combined")
+		}
+		if output.Template, err = parseFormat(format); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case command == "show" && len(args) == 1:
+		return showIdentityCLI(store, args[0], output)
+	case command == "show":
+		return fmt.Errorf("usage: gitid show <identifier> [--json|--format <template>]")
+	case len(args) > 0:
+		return fmt.Errorf("usage: gitid %s [--json|--format <template>]", command)
+	case command == "list":
+		return listIdentitiesCLI(store, output)
+	default:
+		return getCurrentIdentityCLI(store, output)
+	}
+}
+
+func listIdentitiesCLI(store IdentityStore, output OutputOptions) error {
+	identities, err := store.List()
+	if err != nil {
+		return err
+	}
+	if output.JSON || output.Template != nil {
+		current, err := activeIdentity()
+		if err != nil {
+			return err
+		}
+		doc := IdentityListJSON{Version: jsonSchemaVersion, Identities: []IdentityJSON{}}
+		for _, identity := range identities {
+			doc.Identities = append(doc.Identities, toIdentityJSON(identity, current))
+		}
+		if output.JSON {
+			return writeJSON(os.Stdout, doc)
+		}
+		for _, identity := range doc.Identities {
+			if err := writeFormat(os.Stdout, output.Template, identity); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	if len(identities) == 0 {
 		fmt.Println("No identities configured.")
 		return nil
@@ -111,58 +343,207 @@ func listIdentitiesCLI() error {
 	return nil
 }
 
-func getCurrentIdentityCLI() error {
-	nameOut, err := exec.Command("git", "config", "--global", "user.name").Output()
+func getCurrentIdentityCLI(store IdentityStore, output OutputOptions) error {
+	current, err := getEffectiveIdentity()
 	if err != nil {
-		return fmt.Errorf("no git identity configured")
+		return err
+	}
+	if output.JSON || output.Template != nil {
+		identity := current.Identity
+		stored, found, err := store.Get(current.ID)
+		if err != nil {
+			return err
+		}
+		if found {
+			identity.SigningFormat = stored.SigningFormat
+			identity.SigningKey = stored.SigningKey
+			identity.SSHKey = stored.SSHKey
+			identity.Tags = stored.Tags
+		}
+		result := toIdentityJSON(identity, &current)
+		if output.JSON {
+			return writeJSON(os.Stdout, IdentityResultJSON{Version: jsonSchemaVersion, Identity: result})
+		}
+		return writeFormat(os.Stdout, output.Template, result)
+	}
+
+	fmt.Println(getIdentityDisplay(current.Identity))
+	fmt.Printf("Scope: %s (%s)\n", current.Scope, current.Origin)
+	if current.Binding.Kind != "" {
+		fmt.Printf("Selected by binding: %s\n", current.Binding.condition())
 	}
-	emailOut, err := exec.Command("git", "config", "--global", "user.email").Output()
+	return nil
+}
+
+func showIdentityCLI(store IdentityStore, identifier string, output OutputOptions) error {
+	identity, err := findIdentity(store, identifier)
 	if err != nil {
-		return fmt.Errorf("no git identity configured")
+		return err
+	}
+	current, err := activeIdentity()
+	if err != nil {
+		return err
+	}
+
+	result := toIdentityJSON(identity, current)
+	if output.JSON {
+		return writeJSON(os.Stdout, IdentityResultJSON{Version: jsonSchemaVersion, Identity: result})
+	}
+	if output.Template != nil {
+		return writeFormat(os.Stdout, output.Template, result)
 	}
 
-	name := strings.TrimSpace(string(nameOut))
-	email := strings.TrimSpace(string(emailOut))
+	fmt.Println(getIdentityDisplay(identity))
+	if identity.SigningKey != "" {
+		fmt.Printf("Signing: %s %s\n", identity.SigningFormat, identity.SigningKey)
+	}
+	if identity.SSHKey != "" {
+		fmt.Printf("SSH key: %s\n", identity.SSHKey)
+	}
+	if tags := identity.tagList(); len(tags) > 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if result.Active {
+		fmt.Printf("Active: %s (%s)\n", result.Scope, result.Origin)
+	}
+	return nil
+}
 
-	nickname := getNickname(email)
-	if nickname != "" {
-		fmt.Printf("%s (%s <%s>)\n", nickname, name, email)
+func switchIdentityCLI(store IdentityStore, identifier string, target ConfigTarget) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
+	}
+	return setActiveCLI(store, identity, target)
+}
+
+func setActiveCLI(store IdentityStore, identity Identity, target ConfigTarget) error {
+	if err := store.SetActive(identity, target); err != nil {
+		return err
+	}
+	display := getIdentityDisplay(identity)
+	if target.Scope == ScopeGlobal {
+		fmt.Printf("Switched to %s\n", display)
 	} else {
-		fmt.Printf("%s <%s>\n", name, email)
+		fmt.Printf("Switched to %s (%s)\n", display, target)
 	}
 	return nil
 }
 
-func switchIdentityCLI(identifier string) error {
-	identity, found := findIdentityByIdentifier(identifier)
+// pickSwitchCLI lets the user search for the identity to switch to.
+func pickSwitchCLI(store IdentityStore, target ConfigTarget) error {
+	identities, err := store.List()
+	if err != nil {
+		return err
+	}
+	if len(identities) == 0 {
+		return fmt.Errorf("%w: no identities configured", errIdentityNotFound)
+	}
+	identity, ok, err := pickIdentity("Switch to", "", identities)
+	if err != nil || !ok {
+		return err
+	}
+	return setActiveCLI(store, identity, target)
+}
+
+// findCLI lists every identity query matches, best first, with its score.
+func findCLI(store IdentityStore, query string) error {
+	identities, err := store.List()
+	if err != nil {
+		return err
+	}
+	if _, exact := store.(exactStore); exact {
+		identities, _ = matchIdentities(identities, query, true)
+	}
+
+	found := false
+	for _, candidate := range rankIdentities(identities, query) {
+		fmt.Printf("%5d  %s\n", candidate.Score, getIdentityDisplay(candidate.Identity))
+		found = true
+	}
 	if !found {
-		return fmt.Errorf("identity not found: %s", identifier)
+		return fmt.Errorf("%w: %s", errIdentityNotFound, query)
 	}
+	return nil
+}
 
-	switchIdentity(identity.Name, identity.Email)
-	display := getIdentityDisplay(identity)
-	fmt.Printf("Switched to %s\n", display)
+const tagUsage = `usage: gitid tag <identifier> <tag>...
+       gitid tag <identifier> --remove <tag>...`
+
+// tagCLI adds tags to an identity, or removes them with --remove.
+func tagCLI(store IdentityStore, args []string) error {
+	remove, args := extractFlag(args, "--remove")
+	if len(args) < 2 {
+		return fmt.Errorf(tagUsage)
+	}
+	identity, err := findIdentity(store, args[0])
+	if err != nil {
+		return err
+	}
+
+	tags := identity.tagList()
+	for _, tag := range args[1:] {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.Contains(tag, ",") {
+			return validationErrorf("invalid tag %q: tags cannot be empty or contain commas", tag)
+		}
+		if i := slices.Index(tags, tag); remove && i >= 0 {
+			tags = slices.Delete(tags, i, i+1)
+		} else if !remove && i < 0 {
+			tags = append(tags, tag)
+		}
+	}
+	identity.Tags = strings.Join(tags, ",")
+	if err := store.Put(identity); err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		fmt.Printf("No tags for %s\n", getIdentityDisplay(identity))
+		return nil
+	}
+	fmt.Printf("Tags for %s: %s\n", getIdentityDisplay(identity), strings.Join(tags, ", "))
 	return nil
 }
 
-func addIdentityCLI(name, email, nickname string) error {
-	if err := addIdentity(name, email, nickname); err != nil {
+func unpinCLI(target ConfigTarget) error {
+	removed, err := unpinIdentity(target)
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		fmt.Printf("No identity pinned in %s config\n", target)
+		return nil
+	}
+	fmt.Printf("Removed identity from %s config\n", target)
+	return nil
+}
+
+func addIdentityCLI(store IdentityStore, identity Identity) error {
+	identity, err := withSigningKey(identity, identity.SigningFormat, identity.SigningKey)
+	if err != nil {
+		return err
+	}
+	if identity, err = withSSHKey(identity, identity.SSHKey); err != nil {
+		return err
+	}
+	if err := addToStore(store, identity); err != nil {
 		return err
 	}
 
-	identity := Identity{Name: name, Email: email, Nickname: nickname}
 	display := getIdentityDisplay(identity)
 	fmt.Printf("Added identity: %s\n", display)
 	return nil
 }
 
-func deleteIdentityCLI(identifier string) error {
-	identity, found := findIdentityByIdentifier(identifier)
-	if !found {
-		return fmt.Errorf("identity not found: %s", identifier)
+func deleteIdentityCLI(store IdentityStore, identifier string) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
 	}
 
-	if err := deleteIdentity(identity.Email); err != nil {
+	if err := store.Delete(identity.ID); err != nil {
 		return err
 	}
 
@@ -171,13 +552,14 @@ func deleteIdentityCLI(identifier string) error {
 	return nil
 }
 
-func setNicknameCLI(identifier, nickname string) error {
-	identity, found := findIdentityByIdentifier(identifier)
-	if !found {
-		return fmt.Errorf("identity not found: %s", identifier)
+func setNicknameCLI(store IdentityStore, identifier, nickname string) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
 	}
 
-	if err := setNickname(identity.Email, nickname); err != nil {
+	identity.Nickname = nickname
+	if err := store.Put(identity); err != nil {
 		return err
 	}
 
@@ -185,6 +567,539 @@ func setNicknameCLI(identifier, nickname string) error {
 	return nil
 }
 
+const signingUsage = `usage: gitid signing <identifier> [--ssh] <key>
+       gitid signing <identifier> --gpg [fingerprint]
+       gitid signing <identifier> --x509 [id]
+       gitid signing <identifier> --remove`
+
+// parseSigningFlags removes --signing-key, --gpg-key or --x509-key from
+// args and returns the signing format and key they select.
+func parseSigningFlags(args []string) (string, string, []string, error) {
+	format, key := "", ""
+	flags := []struct{ flag, format string }{
+		{"--signing-key", SigningSSH},
+		{"--gpg-key", SigningOpenPGP},
+		{"--x509-key", SigningX509},
+	}
+
+	for _, f := range flags {
+		value, rest, err := extractFlagValue(args, f.flag)
+		if err != nil {
+			return "", "", nil, err
+		}
+		args = rest
+		if value == "" {
+			continue
+		}
+		if key != "" {
+			return "", "", nil, fmt.Errorf("only one of --signing-key, --gpg-key or --x509-key may be given")
+		}
+		format, key = f.format, value
+	}
+	return format, key, args, nil
+}
+
+func setSigningKeyCLI(store IdentityStore, identifier string, args []string) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
+	}
+
+	format, key := SigningSSH, ""
+	switch args[0] {
+	case "--remove":
+		identity.SigningFormat, identity.SigningKey = "", ""
+		if err := store.Put(identity); err != nil {
+			return err
+		}
+		fmt.Printf("Removed signing key for %s\n", getIdentityDisplay(identity))
+		return nil
+	case "--ssh":
+		if len(args) < 2 {
+			return fmt.Errorf(signingUsage)
+		}
+		key = args[1]
+	case "--gpg", "--x509":
+		format = SigningOpenPGP
+		if args[0] == "--x509" {
+			format = SigningX509
+		}
+		if len(args) > 1 {
+			key = args[1]
+			break
+		}
+
+		keys, err := usableSecretKeys(format, identity.Email)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no usable %s secret keys for %s; run 'gitid keys' to list all keys", format, identity.Email)
+		}
+		var choices []string
+		for _, k := range keys {
+			choices = append(choices, k.String())
+		}
+		choice, ok := pick("Signing key for "+getIdentityDisplay(identity), choices)
+		if !ok {
+			return nil
+		}
+		key = keys[choice].Fingerprint
+	default:
+		key = args[0]
+	}
+
+	if identity, err = withSigningKey(identity, format, key); err != nil {
+		return err
+	}
+	if err := store.Put(identity); err != nil {
+		return err
+	}
+	fmt.Printf("Set %s signing key for %s\n", format, getIdentityDisplay(identity))
+	return nil
+}
+
+func setSSHKeyCLI(store IdentityStore, identifier, key string) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
+	}
+
+	if key == "--remove" {
+		identity.SSHKey = ""
+		if err := store.Put(identity); err != nil {
+			return err
+		}
+		fmt.Printf("Removed SSH key for %s\n", getIdentityDisplay(identity))
+		return nil
+	}
+
+	if identity, err = withSSHKey(identity, key); err != nil {
+		return err
+	}
+	if err := store.Put(identity); err != nil {
+		return err
+	}
+	fmt.Printf("Set SSH key for %s\n", getIdentityDisplay(identity))
+	return nil
+}
+
+func listKeysCLI(store IdentityStore, args []string) error {
+	format := SigningOpenPGP
+	if len(args) > 0 && args[0] == "--x509" {
+		format = SigningX509
+		args = args[1:]
+	}
+
+	email := ""
+	if len(args) > 0 {
+		email = args[0]
+		if identity, err := findIdentity(store, args[0]); err == nil {
+			email = identity.Email
+		}
+	}
+
+	keys, err := usableSecretKeys(format, email)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		fmt.Printf("No usable %s secret keys found.\n", format)
+		return nil
+	}
+
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	return nil
+}
+
+const bindUsage = `usage: gitid bind <dir> <identifier>
+       gitid bind --remote <url-glob> <identifier>
+       gitid bind --branch <branch-glob> <identifier>
+       gitid bind --list`
+
+const unbindUsage = `usage: gitid unbind <dir>
+       gitid unbind --remote <url-glob>
+       gitid unbind --branch <branch-glob>`
+
+// parseBindCondition reads the binding condition from the front of args:
+// --remote <glob>, --branch <glob> or a directory. It returns the remaining arguments.
+func parseBindCondition(args []string) (BindingKind, string, []string, error) {
+	if len(args) == 0 {
+		return "", "", nil, fmt.Errorf("missing binding condition")
+	}
+
+	switch args[0] {
+	case "--remote":
+		if len(args) < 2 {
+			return "", "", nil, fmt.Errorf("--remote requires a URL pattern")
+		}
+		return BindRemote, args[1], args[2:], nil
+	case "--branch":
+		if len(args) < 2 {
+			return "", "", nil, fmt.Errorf("--branch requires a branch pattern")
+		}
+		return BindBranch, args[1], args[2:], nil
+	}
+
+	pattern, err := normalizeGitdirPattern(args[0])
+	if err != nil {
+		return "", "", nil, err
+	}
+	return BindGitdir, pattern, args[1:], nil
+}
+
+func bindCLI(store IdentityStore, args []string) error {
+	if len(args) == 1 && args[0] == "--list" {
+		return listBindingsCLI()
+	}
+
+	kind, pattern, rest, err := parseBindCondition(args)
+	if err != nil || len(rest) != 1 {
+		return fmt.Errorf(bindUsage)
+	}
+
+	identity, err := findIdentity(store, rest[0])
+	if err != nil {
+		return err
+	}
+
+	binding, err := bindIdentity(identity, kind, pattern)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Bound %s to %s\n", binding.condition(), getIdentityDisplay(identity))
+	return nil
+}
+
+func unbindCLI(args []string) error {
+	kind, pattern, rest, err := parseBindCondition(args)
+	if err != nil || len(rest) != 0 {
+		return fmt.Errorf(unbindUsage)
+	}
+
+	binding, err := unbindIdentity(kind, pattern)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed binding %s\n", binding.condition())
+	return nil
+}
+
+func listBindingsCLI() error {
+	bindings, err := listBindings()
+	if err != nil {
+		return err
+	}
+	if len(bindings) == 0 {
+		fmt.Println("No bindings configured.")
+		return nil
+	}
+
+	for _, binding := range bindings {
+		display := "(unknown identity)"
+		if identity, found := bindingIdentity(binding); found {
+			display = getIdentityDisplay(identity)
+		}
+		fmt.Printf("%-30s %s\n", binding.condition(), display)
+	}
+	return nil
+}
+
+func execCLI(store IdentityStore, args []string) error {
+	if len(args) > 1 && args[1] == "--" {
+		args = append(args[:1], args[2:]...)
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: gitid exec <identifier> -- <command> [args...]")
+	}
+
+	identity, err := findIdentity(store, args[0])
+	if err != nil {
+		return err
+	}
+	return runAsIdentity(identity, args[1], args[2:]...)
+}
+
+func shellCLI(store IdentityStore, identifier string) error {
+	identity, err := findIdentity(store, identifier)
+	if err != nil {
+		return err
+	}
+	return runIdentityShell(identity)
+}
+
+func envCLI(store IdentityStore, args []string) error {
+	format, rest, err := extractFlagValue(args, "--format")
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: gitid env <identifier> [--format sh|fish|docker]")
+	}
+	// sh is the default whatever the login shell: direnv and most other
+	// consumers evaluate the output with a POSIX shell.
+	if format == "" {
+		format = EnvFormatSh
+	}
+
+	identity, err := findIdentity(store, rest[0])
+	if err != nil {
+		return err
+	}
+
+	// A Docker env file is read in a fresh environment, so overrides are
+	// numbered from zero rather than after the ones in this shell.
+	environ := os.Environ()
+	if format == EnvFormatDocker {
+		environ = nil
+	}
+	env, err := identityEnv(identity, environ)
+	if err != nil {
+		return err
+	}
+
+	output, err := formatEnv(env, format)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	return nil
+}
+
+func exportCLI(store IdentityStore, args []string) error {
+	redact, rest := extractFlag(args, "--redact")
+	if len(rest) > 1 {
+		return fmt.Errorf("usage: gitid export [--redact] [file]")
+	}
+
+	catalog, err := exportCatalog(store, redact)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 || rest[0] == "-" {
+		fmt.Print(catalog)
+		return nil
+	}
+	if err := os.WriteFile(rest[0], []byte(catalog), 0o600); err != nil {
+		return fmt.Errorf("error writing %s: %w", rest[0], err)
+	}
+	fmt.Printf("Exported identities to %s\n", rest[0])
+	return nil
+}
+
+func importCLI(store IdentityStore, args []string) error {
+	dryRun, rest := extractFlag(args, "--dry-run")
+	value, rest, err := extractFlagValue(rest, "--on-conflict")
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("usage: gitid import [--dry-run] [--on-conflict skip|overwrite|rename] <file>")
+	}
+	strategy, err := parseImportStrategy(value)
+	if err != nil {
+		return err
+	}
+
+	actions, err := importCatalog(store, rest[0], strategy, dryRun)
+	for _, action := range actions {
+		line := fmt.Sprintf("%-10s %s", action.Kind, getIdentityDisplay(action.Identity))
+		if action.Reason != "" {
+			line += " (" + action.Reason + ")"
+		}
+		fmt.Println(line)
+	}
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Println("Dry run: no changes were made.")
+	}
+	return nil
+}
+
+func promptCLI(args []string) error {
+	format, rest, err := extractFlagValue(args, "--format")
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: gitid prompt [--format <template>]")
+	}
+
+	output, err := renderPrompt(format)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	return nil
+}
+
+func migrateCLI(args []string) error {
+	dryRun, rest := extractFlag(args, "--dry-run")
+	if len(rest) > 0 {
+		return fmt.Errorf("usage: gitid migrate [--dry-run]")
+	}
+
+	if dryRun {
+		legacy, err := legacyIdentities()
+		if err != nil {
+			return err
+		}
+		for _, identity := range legacy {
+			fmt.Printf("migrate %s (identity.%s)\n", getIdentityDisplay(identity), identity.ID)
+		}
+		if len(legacy) == 0 {
+			fmt.Println("Identities already use the current layout.")
+		}
+		fmt.Println("Dry run: no changes were made.")
+		return nil
+	}
+
+	migrated, err := migrateIdentities()
+	for _, identity := range migrated {
+		fmt.Printf("Migrated %s to identity.%s\n", getIdentityDisplay(identity), identity.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if len(migrated) == 0 {
+		fmt.Println("Identities already use the current layout.")
+	}
+	return nil
+}
+
+const hookUsage = `usage: gitid hook install [--global] [--expect <identifier>]
+       gitid hook uninstall [--global]
+       gitid hook status`
+
+func hookCLI(store IdentityStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(hookUsage)
+	}
+
+	expect, rest, err := extractFlagValue(args[1:], "--expect")
+	if err != nil {
+		return err
+	}
+	global := false
+	for _, arg := range rest {
+		if arg != "--global" {
+			return fmt.Errorf(hookUsage)
+		}
+		global = true
+	}
+
+	switch args[0] {
+	case "install":
+		return installHookCLI(store, global, expect)
+	case "uninstall":
+		return uninstallHookCLI(global)
+	case "status":
+		return hookStatusCLI()
+	case "check":
+		return checkCommitIdentity()
+	default:
+		return fmt.Errorf(hookUsage)
+	}
+}
+
+func installHookCLI(store IdentityStore, global bool, expect string) error {
+	if expect != "" {
+		identity, err := findIdentity(store, expect)
+		if err != nil {
+			return err
+		}
+		if err := expectIdentity(identity); err != nil {
+			return err
+		}
+		fmt.Printf("This repository now expects %s\n", getIdentityDisplay(identity))
+	}
+
+	if global {
+		dir, err := installGlobalHook()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed global pre-commit guard in %s\n", dir)
+		return nil
+	}
+
+	path, err := installRepoHook()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Installed pre-commit guard in %s\n", path)
+	return nil
+}
+
+func uninstallHookCLI(global bool) error {
+	var removed bool
+	var err error
+	if global {
+		removed, err = uninstallGlobalHook()
+	} else {
+		removed, err = uninstallRepoHook()
+		if err == nil {
+			err = expectIdentity(Identity{})
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if !removed {
+		fmt.Println("No gitid pre-commit guard installed.")
+		return nil
+	}
+	fmt.Println("Removed pre-commit guard.")
+	return nil
+}
+
+func hookStatusCLI() error {
+	dir, err := globalHooksDir()
+	if err != nil {
+		return err
+	}
+	if getConfigAt(globalTarget, "core.hooksPath") == dir {
+		fmt.Printf("Global guard:     installed (%s)\n", dir)
+		if previous, isSet := lookupConfigAt(globalTarget, previousHooksPathKey); isSet {
+			fmt.Printf("Chains to:        %s\n", previous)
+		} else {
+			fmt.Println("Chains to:        repository hooks")
+		}
+	} else {
+		fmt.Println("Global guard:     not installed")
+	}
+
+	path, err := repoHookPath()
+	if err != nil {
+		return nil
+	}
+	if isGitidHook(path) {
+		fmt.Printf("Repository guard: installed (%s)\n", path)
+	} else {
+		fmt.Println("Repository guard: not installed")
+	}
+
+	expected, reason, found := expectedIdentity()
+	if !found {
+		fmt.Println("Expected:         (none)")
+		return nil
+	}
+	fmt.Printf("Expected:         %s (%s)\n", getIdentityDisplay(expected), reason)
+	if err := checkCommitIdentity(); err != nil {
+		fmt.Println("Status:           mismatch")
+	} else {
+		fmt.Println("Status:           ok")
+	}
+	return nil
+}
+
 func completionCLI(args []string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("usage: gitid completion <shell> [-r]\nSupported shells: bash, zsh, fish")
@@ -228,6 +1143,56 @@ func completionCLI(args []string) error {
 	return nil
 }
 
+// extractFlag removes every occurrence of the boolean flag from args and
+// reports whether it was present.
+func extractFlag(args []string, flag string) (bool, []string) {
+	found := false
+	var rest []string
+	for _, arg := range args {
+		if arg == flag {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return found, rest
+}
+
+// extractExactFlag removes --exact from anywhere in args up to a "--",
+// after which the arguments belong to another command.
+func extractExactFlag(args []string) (bool, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			found, rest := extractFlag(args[:i], "--exact")
+			return found, append(rest, args[i:]...)
+		}
+	}
+	return extractFlag(args, "--exact")
+}
+
+// extractFlagValue removes "flag <value>" or "flag=<value>" from args and
+// returns the value along with the remaining arguments.
+func extractFlagValue(args []string, flag string) (string, []string, error) {
+	var value string
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == flag:
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("%s requires a value", flag)
+			}
+			i++
+			value = args[i]
+		case strings.HasPrefix(args[i], flag+"="):
+			value = strings.TrimPrefix(args[i], flag+"=")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return value, rest, nil
+}
+
 func detectCurrentShell() string {
 	shell := os.Getenv("SHELL")
 	if shell == "" {
@@ -253,23 +1218,100 @@ func showHelp() {
 USAGE:
     gitid                           Launch interactive TUI
     gitid list                      List all identities
-    gitid current                   Show current git identity
-    gitid switch <identifier>       Switch to identity by nickname, name, or email
-    gitid use <identifier>          Alias for switch
+    gitid current                   Show effective git identity and where it comes from
+    gitid show <id>                 Show an identity's details and whether it is active
+    gitid switch [identifier]       Switch to identity by nickname, name, email or tag;
+                                    without one, search for it interactively
+    gitid use [identifier]          Alias for switch
+    gitid find <query>              List identities matching query, best first, with scores
+    gitid unpin                     Remove the identity pinned to the current repository
     gitid add <name> <email> [nick] Add new identity with optional nickname
+        [--signing-key <key>]       and SSH,
+        [--gpg-key <fingerprint>]   OpenPGP
+        [--x509-key <id>]           or X.509 signing key
+        [--ssh-key <path>]          and SSH key for pushing and fetching
     gitid delete <identifier>       Delete identity
     gitid nickname <id> <nickname>  Set/update nickname for identity
+    gitid tag <id> <tag>...         Add tags to identity to search it by
+    gitid tag <id> --remove <tag>.. Remove tags from identity
+    gitid signing <id> <key>        Sign commits and tags with an SSH key (path or key::literal)
+    gitid signing <id> --gpg [fpr]  Sign with an OpenPGP key (pick one when fpr is omitted)
+    gitid signing <id> --x509 [id]  Sign with an X.509 certificate from gpgsm
+    gitid signing <id> --remove     Stop signing for identity
+    gitid sshkey <id> <path>        Push and fetch with a private SSH key (core.sshCommand)
+    gitid sshkey <id> --remove      Use the default SSH configuration again
+    gitid keys [--x509] [id]        List usable secret keys, optionally for an identity
+    gitid bind <dir> <identifier>   Use identity for every repository under dir
+    gitid bind --remote <glob> <id> Use identity for every clone whose remote URL matches glob
+    gitid bind --branch <glob> <id> Use identity while a branch matching glob is checked out
+    gitid bind --list               List bindings
+    gitid unbind <dir>              Remove a directory binding
+    gitid unbind --remote <glob>    Remove a remote binding
+    gitid unbind --branch <glob>    Remove a branch binding
+    gitid hook install [--global]   Block commits made with the wrong identity
+        [--expect <id>]             and pin the identity this repository expects
+    gitid hook uninstall [--global] Remove the pre-commit guard
+    gitid hook status               Show guard installation and expected identity
+    gitid exec <id> -- <command>    Run one command as identity without changing any config
+    gitid shell <id>                Start a subshell that uses identity; exit to return
+    gitid env <id> [--format f]     Print identity as environment variables (sh by default;
+                                    fish and docker with --format)
+    gitid export [--redact] [file]  Write all identities as TOML (stdout by default)
+    gitid import <file>             Add identities from an exported catalog
+        [--on-conflict s]           skip (default), overwrite or rename conflicting ones
+        [--dry-run]                 and only show what would change
+    gitid prompt [--format tpl]     Print the effective identity for a shell prompt
+    gitid migrate [--dry-run]       Move identities stored by older versions to stable IDs
     gitid completion <shell>        Install shell completion (bash/zsh/fish)
     gitid completion <shell> -r     Remove shell completion
+    gitid --dry-run <command>       Show what add, delete, nickname, tag, switch, signing,
+                                    sshkey or import would do without changing anything
+    gitid --exact <command>         Only accept exact nicknames, emails and names as
+                                    identifiers, never partial ones
     gitid help                      Show this help
 
+OUTPUT FLAGS (list, current, show):
+    --json                          Print a versioned JSON document
+    --format <template>             Print each identity through a Go template, or one of
+                                    the named formats short, full and author
+
+SCOPE FLAGS (switch, use, unpin):
+    --global                        Write to ~/.gitconfig (default for switch)
+    --local                         Write to the current repository (default for unpin)
+    --worktree                      Write to the current worktree
+    --file <path>                   Write to the given config file
+
+EXIT CODES:
+    0 success, 1 other failure, 2 identity not found, 3 ambiguous identifier,
+    4 no identity configured, 5 git not installed, 6 config file locked,
+    7 invalid input (nothing written), 8 change only partly written
+
 EXAMPLES:
     gitid list
     gitid current
+    gitid list --json | jq -r '.identities[] | select(.active) | .email'
+    gitid list --format '{{.Nickname}}\t{{.Email}}'
+    git commit --author="$(gitid current --format author)"
     gitid switch work
+    gitid switch --local oss
+    gitid tag work client-acme
+    gitid find acme
+    gitid unpin
     gitid add "John Doe" "john@company.com" work
     gitid nickname john@company.com work
+    gitid signing work ~/.ssh/id_ed25519_work.pub
+    gitid signing oss --gpg 3AA5C34371567BD2
+    gitid sshkey work ~/.ssh/id_ed25519_work
+    gitid bind ~/work/ work
+    gitid bind --remote 'git@github.com:acme/**' work
+    gitid bind --branch 'upstream/*' oss
     gitid delete work
+    gitid hook install --expect work
+    gitid exec bot -- git am patches/*.patch
+    eval "$(gitid env work)"
+    gitid export > identities.toml
+    gitid import --dry-run --on-conflict rename identities.toml
+    gitid prompt --format '{{.Nickname}} ({{.Scope}})'
     gitid completion bash
     gitid completion zsh -r`)
 }
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// configBackendEnv selects the config backend: "git" or "native". Without
+// it gitid uses git when it is installed and the native backend otherwise.
+const configBackendEnv = "GITID_CONFIG_BACKEND"
+
+func currentConfigBackend() configBackend {
+	switch os.Getenv(configBackendEnv) {
+	case "native":
+		return nativeBackend{}
+	case "git":
+		return gitBackend{}
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nativeBackend{}
+	}
+	return gitBackend{}
+}
+
+// exitCode returns the exit code of a git command that ran and failed, or
+// -1 when err is something else.
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+func (gitBackend) Get(target ConfigTarget, key string) (string, bool, error) {
+	out, err := gitConfigAt(target, "--get", key).Output()
+	switch {
+	case err == nil:
+		return strings.TrimSuffix(string(out), "\n"), true, nil
+	case exitCode(err) == 1:
+		return "", false, nil
+	default:
+		return "", false, gitError(err, fmt.Errorf("error reading %s from %s config: %w", key, target, err))
+	}
+}
+
+func (gitBackend) GetRegexp(target ConfigTarget, pattern string) ([]configEntry, error) {
+	out, err := gitConfigAt(target, "--null", "--get-regexp", pattern).Output()
+	if exitCode(err) == 1 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, gitError(err, fmt.Errorf("error reading %s config: %w", target, err))
+	}
+
+	var entries []configEntry
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		key, value, found := strings.Cut(entry, "\n")
+		entries = append(entries, configEntry{Key: key, Value: value, Implicit: !found})
+	}
+	return entries, nil
+}
+
+// writeGitConfig runs a git config command that writes target. Git's
+// failure to take the config file's lock is reported as errConfigLocked.
+func writeGitConfig(target ConfigTarget, args ...string) error {
+	var stderr strings.Builder
+	cmd := gitConfigAt(target, args...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if message := strings.TrimSpace(stderr.String()); err != nil && strings.Contains(message, "could not lock config file") {
+		return fmt.Errorf("%w: %s", errConfigLocked, strings.TrimPrefix(message, "error: "))
+	}
+	return gitError(err, err)
+}
+
+func (gitBackend) Set(target ConfigTarget, key, value string) error {
+	return writeGitConfig(target, key, value)
+}
+
+func (gitBackend) Add(target ConfigTarget, key, value string) error {
+	return writeGitConfig(target, "--add", key, value)
+}
+
+func (gitBackend) UnsetAll(target ConfigTarget, key string) (bool, error) {
+	err := writeGitConfig(target, "--unset-all", key)
+	switch {
+	case err == nil:
+		return true, nil
+	case exitCode(err) == 5:
+		// Key was not set in this scope.
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (gitBackend) RemoveSection(target ConfigTarget, section string) error {
+	return writeGitConfig(target, "--remove-section", section)
+}
+
+func (gitBackend) Effective(key string) (configEntry, string, bool, error) {
+	out, err := exec.Command("git", "config", "--show-scope", "--show-origin", "--null", "--get", key).Output()
+	if exitCode(err) == 1 {
+		return configEntry{}, "", false, nil
+	}
+	if err != nil {
+		return configEntry{}, "", false, gitError(err, fmt.Errorf("error reading %s: %w", key, err))
+	}
+
+	fields := strings.SplitN(strings.TrimSuffix(string(out), "\x00"), "\x00", 3)
+	if len(fields) != 3 {
+		return configEntry{}, "", false, fmt.Errorf("unexpected git config output: %q", out)
+	}
+	entry := configEntry{Key: key, Value: fields[2], Origin: strings.TrimPrefix(fields[1], "file:")}
+	return entry, fields[0], true, nil
+}
+
+// canonicalKey lower-cases the section and variable name of key, which
+// git compares case-insensitively, and keeps the subsection as written.
+func canonicalKey(key string) (string, error) {
+	section, subsection, name, hasSubsection, err := splitConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+	if hasSubsection {
+		return strings.ToLower(section) + "." + subsection + "." + strings.ToLower(name), nil
+	}
+	return strings.ToLower(section) + "." + strings.ToLower(name), nil
+}
+
+// configPaths returns the files a target is read from, in the order git
+// reads them, and the file it is written to.
+func configPaths(target ConfigTarget) ([]string, string, error) {
+	switch target.Scope {
+	case ScopeFile:
+		return []string{target.File}, target.File, nil
+	case ScopeLocal, ScopeWorktree:
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, "", err
+		}
+		gitDir, commonDir, found := findGitDir(dir)
+		if !found {
+			return nil, "", fmt.Errorf("%s config requires a git repository", target)
+		}
+		path := filepath.Join(commonDir, "config")
+		if target.Scope == ScopeWorktree && worktreeConfigEnabled(path) {
+			path = filepath.Join(gitDir, "config.worktree")
+		}
+		return []string{path}, path, nil
+	}
+
+	if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+		return []string{path}, path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, "", fmt.Errorf("error locating global config: %w", err)
+	}
+	xdg := filepath.Join(home, ".config", "git", "config")
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		xdg = filepath.Join(dir, "git", "config")
+	}
+	path := filepath.Join(home, ".gitconfig")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if _, err := os.Stat(xdg); err == nil {
+			return []string{xdg}, xdg, nil
+		}
+	}
+	return []string{xdg, path}, path, nil
+}
+
+func worktreeConfigEnabled(localConfig string) bool {
+	f, err := readConfigFile(localConfig)
+	if err != nil {
+		return false
+	}
+	value, _ := f.get("extensions.worktreeConfig")
+	enabled, err := strconv.ParseBool(value)
+	return err == nil && enabled
+}
+
+func (nativeBackend) Get(target ConfigTarget, key string) (string, bool, error) {
+	paths, _, err := configPaths(target)
+	if err != nil {
+		return "", false, err
+	}
+	value, found := "", false
+	for _, path := range paths {
+		f, err := readConfigFile(path)
+		if err != nil {
+			return "", false, err
+		}
+		if v, ok := f.get(key); ok {
+			value, found = v, true
+		}
+	}
+	return value, found, nil
+}
+
+func (nativeBackend) GetRegexp(target ConfigTarget, pattern string) ([]configEntry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pattern %s: %w", pattern, err)
+	}
+	paths, _, err := configPaths(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []configEntry
+	for _, path := range paths {
+		f, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range f.entries() {
+			if re.MatchString(entry.Key) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// updateConfig applies change to the file target is written to as one
+// locked transaction; see updateConfigFile. It is used for every change
+// that spans several keys, whichever backend reads the config.
+func updateConfig(target ConfigTarget, change func(*configFile) error) error {
+	_, path, err := configPaths(target)
+	if err != nil {
+		return err
+	}
+	return updateConfigFile(path, change)
+}
+
+// updateConfigs applies change to the config files of targets as one
+// transaction; see updateConfigFiles.
+func updateConfigs(targets []ConfigTarget, change func([]*configFile) error) error {
+	paths := make([]string, len(targets))
+	for i, target := range targets {
+		_, path, err := configPaths(target)
+		if err != nil {
+			return err
+		}
+		paths[i] = path
+	}
+	return updateConfigFiles(paths, change)
+}
+
+func (nativeBackend) Set(target ConfigTarget, key, value string) error {
+	return updateConfig(target, func(f *configFile) error { return f.set(key, value) })
+}
+
+func (nativeBackend) Add(target ConfigTarget, key, value string) error {
+	return updateConfig(target, func(f *configFile) error { return f.add(key, value) })
+}
+
+func (nativeBackend) UnsetAll(target ConfigTarget, key string) (bool, error) {
+	removed := 0
+	err := updateConfig(target, func(f *configFile) error {
+		var err error
+		removed, err = f.unset(key)
+		return err
+	})
+	return removed > 0, err
+}
+
+func (nativeBackend) RemoveSection(target ConfigTarget, section string) error {
+	return updateConfig(target, func(f *configFile) error { return f.removeSection(section) })
+}
+
+// configScopes returns the config files git reads in the current
+// directory, by scope, in the order later ones override earlier ones.
+func configScopes() ([][2]string, *includeContext) {
+	var scopes [][2]string
+	if noSystem, _ := strconv.ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); !noSystem {
+		system := os.Getenv("GIT_CONFIG_SYSTEM")
+		if system == "" {
+			system = "/etc/gitconfig"
+		}
+		scopes = append(scopes, [2]string{"system", system})
+	}
+	if paths, _, err := configPaths(globalTarget); err == nil {
+		for _, path := range paths {
+			scopes = append(scopes, [2]string{"global", path})
+		}
+	}
+
+	ctx := &includeContext{}
+	dir, err := os.Getwd()
+	if err != nil {
+		return scopes, ctx
+	}
+	gitDir, commonDir, found := findGitDir(dir)
+	if !found {
+		return scopes, ctx
+	}
+	if abs, err := filepath.Abs(gitDir); err == nil {
+		gitDir = abs
+	}
+	ctx.gitDir = gitDir
+	if head, err := os.ReadFile(filepath.Join(gitDir, "HEAD")); err == nil {
+		ctx.branch, _ = strings.CutPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+		if strings.HasPrefix(ctx.branch, "ref: ") || len(ctx.branch) == 40 {
+			// Detached, or a symbolic ref outside refs/heads.
+			ctx.branch = ""
+		}
+	}
+
+	local := filepath.Join(commonDir, "config")
+	scopes = append(scopes, [2]string{"local", local})
+	if f, err := readConfigFile(local); err == nil {
+		for _, entry := range f.entries() {
+			if strings.HasPrefix(entry.Key, "remote.") && strings.HasSuffix(entry.Key, ".url") {
+				ctx.remoteURLs = append(ctx.remoteURLs, entry.Value)
+			}
+		}
+	}
+	if worktreeConfigEnabled(local) {
+		scopes = append(scopes, [2]string{"worktree", filepath.Join(gitDir, "config.worktree")})
+	}
+	return scopes, ctx
+}
+
+// commandConfig returns the variables passed through GIT_CONFIG_COUNT,
+// GIT_CONFIG_KEY_<n> and GIT_CONFIG_VALUE_<n>.
+func commandConfig() []configEntry {
+	count, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	var entries []configEntry
+	for i := 0; i < count; i++ {
+		key, err := canonicalKey(os.Getenv(fmt.Sprintf("GIT_CONFIG_KEY_%d", i)))
+		if err != nil {
+			continue
+		}
+		value := os.Getenv(fmt.Sprintf("GIT_CONFIG_VALUE_%d", i))
+		entries = append(entries, configEntry{Key: key, Value: value, Origin: "command line:"})
+	}
+	return entries
+}
+
+func (nativeBackend) Effective(key string) (configEntry, string, bool, error) {
+	key, err := canonicalKey(key)
+	if err != nil {
+		return configEntry{}, "", false, err
+	}
+
+	var result configEntry
+	scope, found := "", false
+	scopes, ctx := configScopes()
+	for _, s := range scopes {
+		entries, err := readConfigEntries(s[1], ctx)
+		if err != nil {
+			return configEntry{}, "", false, err
+		}
+		for _, entry := range entries {
+			if entry.Key == key {
+				result, scope, found = entry, s[0], true
+			}
+		}
+	}
+	for _, entry := range commandConfig() {
+		if entry.Key == key {
+			result, scope, found = entry, "command", true
+		}
+	}
+	return result, scope, found, nil
+}
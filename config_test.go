@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func writeInclude(t *testing.T, name, email string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".gitconfig")
+	if err := os.WriteFile(path, []byte("[user]\n\temail = "+email+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func realPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+func TestNativeEffectiveMatchesGit(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	repo := setupTestRepo(t)
+
+	git := gitBackend{}
+	global := [][2]string{
+		{"user.name", "Global User"},
+		{"user.email", "global@example.com"},
+		{"includeIf.gitdir:" + filepath.ToSlash(repo) + "/.path", writeInclude(t, "gitdir", "gitdir@example.com")},
+		{"includeIf.onbranch:feature/**.path", writeInclude(t, "branch", "branch@example.com")},
+		{"includeIf.hasconfig:remote.*.url:git@github.com:acme/**.path", writeInclude(t, "remote", "remote@example.com")},
+	}
+	for _, kv := range global {
+		if err := git.Set(globalTarget, kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git.Set(ConfigTarget{Scope: ScopeLocal}, "user.name", "Local User")
+
+	states := []struct {
+		name  string
+		setup func()
+	}{
+		{"gitdir include", func() {}},
+		{"branch include", func() { exec.Command("git", "checkout", "-q", "-b", "feature/x").Run() }},
+		{"remote include", func() { exec.Command("git", "remote", "add", "origin", "git@github.com:acme/app.git").Run() }},
+		{"command line", func() {
+			t.Setenv("GIT_CONFIG_COUNT", "1")
+			t.Setenv("GIT_CONFIG_KEY_0", "User.Email")
+			t.Setenv("GIT_CONFIG_VALUE_0", "env@example.com")
+		}},
+	}
+	for _, state := range states {
+		state.setup()
+		for _, key := range []string{"user.email", "user.name"} {
+			want, wantScope, wantFound, err := git.Effective(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, gotScope, gotFound, err := nativeBackend{}.Effective(key)
+			if err != nil {
+				t.Fatalf("%s: native Effective(%s) failed: %v", state.name, key, err)
+			}
+			if got.Value != want.Value || gotScope != wantScope || gotFound != wantFound {
+				t.Errorf("%s: native %s = %q (%s), git has %q (%s)", state.name, key, got.Value, gotScope, want.Value, wantScope)
+			}
+			if wantScope != "command" && realPath(got.Origin) != realPath(want.Origin) {
+				t.Errorf("%s: native origin of %s = %s, git has %s", state.name, key, got.Origin, want.Origin)
+			}
+		}
+	}
+}
+
+func TestIdentitiesWithoutGit(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	repo := setupTestRepo(t)
+	t.Setenv("PATH", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	if _, ok := currentConfigBackend().(nativeBackend); !ok {
+		t.Fatal("the native backend should be used when git is not installed")
+	}
+
+	if err := addIdentity("Work User", "work@example.com", "work"); err != nil {
+		t.Fatalf("addIdentity without git failed: %v", err)
+	}
+	store := gitConfigStore{}
+	work, err := findIdentity(store, "work")
+	if err != nil {
+		t.Fatalf("findIdentity without git failed: %v", err)
+	}
+	if err := switchIdentity(work, ConfigTarget{Scope: ScopeLocal}); err != nil {
+		t.Fatalf("switch without git failed: %v", err)
+	}
+
+	current, err := getEffectiveIdentity()
+	if err != nil {
+		t.Fatalf("getEffectiveIdentity without git failed: %v", err)
+	}
+	if current.Email != "work@example.com" || current.Nickname != "work" || current.Scope != "local" {
+		t.Errorf("effective identity = %+v, want work from the local scope", current)
+	}
+	if realPath(current.Origin) != realPath(filepath.Join(repo, ".git", "config")) {
+		t.Errorf("origin = %s, want the repository config", current.Origin)
+	}
+
+	work, err = withSigningKey(work, SigningSSH, "key::ssh-ed25519 AAAA")
+	if err != nil {
+		t.Fatalf("withSigningKey without git failed: %v", err)
+	}
+	if err := store.Put(work); err != nil {
+		t.Fatalf("Put without git failed: %v", err)
+	}
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switch to a signing identity without git failed: %v", err)
+	}
+	if value := getConfigAt(globalTarget, "gpg.format"); value != SigningSSH {
+		t.Errorf("gpg.format without git = %q, want ssh", value)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Output formats for gitid env.
+const (
+	EnvFormatSh     = "sh"
+	EnvFormatFish   = "fish"
+	EnvFormatDocker = "docker"
+)
+
+// childExitError carries the exit code of a command gitid ran so that
+// main can exit with the same code.
+type childExitError struct {
+	code int
+}
+
+func (e *childExitError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.code)
+}
+
+// identityConfig returns the config settings that apply identity, in the
+// order they should be passed to git.
+func identityConfig(identity Identity) [][2]string {
+	config := [][2]string{
+		{"user.name", identity.Name},
+		{"user.email", identity.Email},
+	}
+
+	if identity.SigningKey != "" {
+		config = append(config,
+			[2]string{"gpg.format", identity.SigningFormat},
+			[2]string{"user.signingkey", identity.SigningKey},
+			[2]string{"commit.gpgsign", "true"},
+			[2]string{"tag.gpgsign", "true"},
+		)
+	} else {
+		config = append(config,
+			[2]string{"commit.gpgsign", "false"},
+			[2]string{"tag.gpgsign", "false"},
+		)
+	}
+
+	config = append(config, [2]string{"core.sshCommand", identitySSHCommand(identity)})
+	return config
+}
+
+// identityEnv returns the environment variables that make git act as
+// identity without reading or writing any config file. Config overrides
+// are numbered after any GIT_CONFIG_COUNT already present in environ.
+func identityEnv(identity Identity, environ []string) ([][2]string, error) {
+	if identity.SigningKey != "" {
+		if err := validateSigningKey(identity.SigningFormat, identity.SigningKey, identity.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	env := [][2]string{
+		{"GIT_AUTHOR_NAME", identity.Name},
+		{"GIT_AUTHOR_EMAIL", identity.Email},
+		{"GIT_COMMITTER_NAME", identity.Name},
+		{"GIT_COMMITTER_EMAIL", identity.Email},
+		{"GIT_SSH_COMMAND", identitySSHCommand(identity)},
+	}
+
+	offset := 0
+	if count := lookupEnv(environ, "GIT_CONFIG_COUNT"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid GIT_CONFIG_COUNT in environment: %q", count)
+		}
+		offset = n
+	}
+
+	config := identityConfig(identity)
+	for i, setting := range config {
+		n := strconv.Itoa(offset + i)
+		env = append(env,
+			[2]string{"GIT_CONFIG_KEY_" + n, setting[0]},
+			[2]string{"GIT_CONFIG_VALUE_" + n, setting[1]},
+		)
+	}
+	env = append(env, [2]string{"GIT_CONFIG_COUNT", strconv.Itoa(offset + len(config))})
+	return env, nil
+}
+
+// identitySSHCommand returns the ssh command for identity. Identities
+// without a key get plain ssh, so a key inherited from an outer gitid
+// shell or env does not leak into them.
+func identitySSHCommand(identity Identity) string {
+	if identity.SSHKey == "" {
+		return "ssh"
+	}
+	return sshCommandFor(identity.SSHKey)
+}
+
+func lookupEnv(environ []string, name string) string {
+	value := ""
+	for _, entry := range environ {
+		if len(entry) > len(name) && entry[:len(name)] == name && entry[len(name)] == '=' {
+			value = entry[len(name)+1:]
+		}
+	}
+	return value
+}
+
+// withEnv returns environ with the variables in env added or replaced.
+func withEnv(environ []string, env [][2]string) []string {
+	result := append([]string{}, environ...)
+	for _, variable := range env {
+		result = append(result, variable[0]+"="+variable[1])
+	}
+	return result
+}
+
+// runAsIdentity runs name with args under identity, connected to the
+// terminal. A non-zero exit is reported as a *childExitError.
+func runAsIdentity(identity Identity, name string, args ...string) error {
+	env, err := identityEnv(identity, os.Environ())
+	if err != nil {
+		return err
+	}
+	return runWithEnv(env, name, args...)
+}
+
+func runWithEnv(env [][2]string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Env = withEnv(os.Environ(), env)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The child gets terminal signals itself; gitid only waits for it.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &exitErr):
+		// A child killed by a signal exits 128+signal, as in a shell.
+		code := exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			code = 128 + int(status.Signal())
+		} else if code < 0 {
+			code = 1
+		}
+		return &childExitError{code: code}
+	default:
+		return fmt.Errorf("error running %s: %w", name, err)
+	}
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// formatEnv renders env as assignments for format: export statements for
+// POSIX shells, set -gx for fish, or a Docker --env-file.
+func formatEnv(env [][2]string, format string) (string, error) {
+	var b strings.Builder
+	for _, variable := range env {
+		name, value := variable[0], variable[1]
+		switch format {
+		case EnvFormatSh:
+			fmt.Fprintf(&b, "export %s=%s\n", name, shellQuote(value))
+		case EnvFormatFish:
+			fmt.Fprintf(&b, "set -gx %s %s;\n", name, fishQuote(value))
+		case EnvFormatDocker:
+			if strings.ContainsAny(value, "\r\n") {
+				return "", fmt.Errorf("%s contains a newline, which Docker env files cannot represent", name)
+			}
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+		default:
+			return "", fmt.Errorf("unsupported env format: %s\nSupported formats: sh, fish, docker", format)
+		}
+	}
+	return b.String(), nil
+}
+
+// activeIdentityVar is exported inside gitid shell so prompts can show
+// the identity in use.
+const activeIdentityVar = "GITID_ACTIVE"
+
+// subshellPath picks the shell gitid shell starts: the user's $SHELL when
+// detectCurrentShell recognises it, otherwise $SHELL or /bin/sh.
+func subshellPath() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		if detectCurrentShell() != "" {
+			return shell
+		}
+		if _, err := exec.LookPath(shell); err == nil {
+			return shell
+		}
+	}
+	return "/bin/sh"
+}
+
+// runIdentityShell starts an interactive subshell with identity applied
+// through the environment only. Nested shells stack: their config
+// overrides are numbered after the outer shell's and win over them.
+func runIdentityShell(identity Identity) error {
+	env, err := identityEnv(identity, os.Environ())
+	if err != nil {
+		return err
+	}
+
+	label := identity.Email
+	if identity.Nickname != "" {
+		label = identity.Nickname
+	}
+	env = append(env, [2]string{activeIdentityVar, label})
+
+	shell := subshellPath()
+	fmt.Fprintf(os.Stderr, "Entering %s as %s. Exit the shell to return.\n", shell, getIdentityDisplay(identity))
+	err = runWithEnv(env, shell)
+	fmt.Fprintf(os.Stderr, "Left %s shell.\n", label)
+	return err
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func envMap(env [][2]string) map[string]string {
+	m := make(map[string]string)
+	for _, variable := range env {
+		m[variable[0]] = variable[1]
+	}
+	return m
+}
+
+func TestIdentityEnv(t *testing.T) {
+	identity := Identity{Name: "Bot", Email: "bot@example.com", SSHKey: "/keys/bot key"}
+
+	env, err := identityEnv(identity, []string{"GIT_CONFIG_COUNT=2"})
+	if err != nil {
+		t.Fatalf("identityEnv failed: %v", err)
+	}
+	vars := envMap(env)
+
+	expected := map[string]string{
+		"GIT_AUTHOR_NAME":     "Bot",
+		"GIT_AUTHOR_EMAIL":    "bot@example.com",
+		"GIT_COMMITTER_NAME":  "Bot",
+		"GIT_COMMITTER_EMAIL": "bot@example.com",
+		"GIT_SSH_COMMAND":     "ssh -i '/keys/bot key' -o IdentitiesOnly=yes",
+		"GIT_CONFIG_KEY_2":    "user.name",
+		"GIT_CONFIG_VALUE_2":  "Bot",
+		"GIT_CONFIG_KEY_4":    "commit.gpgsign",
+		"GIT_CONFIG_VALUE_4":  "false",
+		"GIT_CONFIG_KEY_6":    "core.sshCommand",
+		"GIT_CONFIG_COUNT":    "7",
+	}
+	for key, value := range expected {
+		if vars[key] != value {
+			t.Errorf("%s = %q, want %q", key, vars[key], value)
+		}
+	}
+	if _, ok := vars["GIT_CONFIG_KEY_0"]; ok {
+		t.Error("identityEnv should not overwrite config overrides already in the environment")
+	}
+
+	if _, err := identityEnv(identity, []string{"GIT_CONFIG_COUNT=many"}); err == nil {
+		t.Error("identityEnv should reject an invalid GIT_CONFIG_COUNT")
+	}
+}
+
+func TestRunAsIdentity(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+
+	bot := Identity{Name: "Bot", Email: "bot@example.com"}
+	if err := runAsIdentity(bot, "git", "commit", "-q", "--allow-empty", "-m", "as bot"); err != nil {
+		t.Fatalf("runAsIdentity(git commit) failed: %v", err)
+	}
+
+	out, _ := exec.Command("git", "log", "-1", "--format=%an <%ae>|%cn <%ce>").Output()
+	if got := strings.TrimSpace(string(out)); got != "Bot <bot@example.com>|Bot <bot@example.com>" {
+		t.Errorf("commit author|committer = %q, want the bot identity", got)
+	}
+	if got := gitConfigValue(t, "--global", "user.email"); got != "me@example.com" {
+		t.Errorf("global user.email = %q after exec, want it untouched", got)
+	}
+
+	output := filepath.Join(t.TempDir(), "email")
+	script := `git config user.email > "$1"; exit 3`
+	err := runAsIdentity(bot, "sh", "-c", script, "sh", output)
+	var childErr *childExitError
+	if !errors.As(err, &childErr) || childErr.code != 3 {
+		t.Errorf("runAsIdentity exit = %v, want child exit status 3", err)
+	}
+	if content, _ := os.ReadFile(output); strings.TrimSpace(string(content)) != "bot@example.com" {
+		t.Errorf("git config user.email inside exec = %q, want bot@example.com", content)
+	}
+
+	err = runAsIdentity(bot, "sh", "-c", "kill -TERM $$")
+	if !errors.As(err, &childErr) || childErr.code != 128+int(syscall.SIGTERM) {
+		t.Errorf("runAsIdentity exit after SIGTERM = %v, want child exit status 143", err)
+	}
+}
+
+func TestFormatEnv(t *testing.T) {
+	env := [][2]string{
+		{"GIT_AUTHOR_NAME", "O'Brien Dev"},
+		{"GIT_AUTHOR_EMAIL", "ob@example.com"},
+	}
+
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{EnvFormatSh, "export GIT_AUTHOR_NAME='O'\\''Brien Dev'\nexport GIT_AUTHOR_EMAIL=ob@example.com\n"},
+		{EnvFormatFish, "set -gx GIT_AUTHOR_NAME 'O\\'Brien Dev';\nset -gx GIT_AUTHOR_EMAIL 'ob@example.com';\n"},
+		{EnvFormatDocker, "GIT_AUTHOR_NAME=O'Brien Dev\nGIT_AUTHOR_EMAIL=ob@example.com\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			result, err := formatEnv(env, tt.format)
+			if err != nil {
+				t.Fatalf("formatEnv(%s) failed: %v", tt.format, err)
+			}
+			if result != tt.expected {
+				t.Errorf("formatEnv(%s) = %q, want %q", tt.format, result, tt.expected)
+			}
+		})
+	}
+
+	if _, err := formatEnv([][2]string{{"X", "a\nb"}}, EnvFormatDocker); err == nil {
+		t.Error("formatEnv(docker) should reject values with newlines")
+	}
+	if _, err := formatEnv(env, "powershell"); err == nil {
+		t.Error("formatEnv should reject unknown formats")
+	}
+}
+
+func TestFormatEnvShRoundTrip(t *testing.T) {
+	identity := Identity{Name: "Dev $HOME `x` \"q\"", Email: "dev@example.com", SSHKey: "/keys/it's"}
+	env, err := identityEnv(identity, nil)
+	if err != nil {
+		t.Fatalf("identityEnv failed: %v", err)
+	}
+	script, _ := formatEnv(env, EnvFormatSh)
+
+	out, err := exec.Command("sh", "-c", script+`printf '%s|%s' "$GIT_AUTHOR_NAME" "$GIT_SSH_COMMAND"`).Output()
+	if err != nil {
+		t.Fatalf("sh failed: %v", err)
+	}
+	if want := identity.Name + "|" + sshCommandFor(identity.SSHKey); string(out) != want {
+		t.Errorf("sh round trip = %q, want %q", out, want)
+	}
+}
+
+func TestRunIdentityShell(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out")
+	shell := filepath.Join(dir, "fakeshell")
+	os.WriteFile(shell, []byte("#!/bin/sh\necho \"$GITID_ACTIVE|$(git config user.email)\" >> "+shellQuote(output)+"\n"), 0o755)
+
+	t.Setenv("SHELL", shell)
+
+	client := Identity{Name: "Client", Email: "client@example.com", Nickname: "client"}
+	if err := runIdentityShell(client); err != nil {
+		t.Fatalf("runIdentityShell failed: %v", err)
+	}
+
+	// Simulate running gitid shell again from inside the first one.
+	outer, _ := identityEnv(client, nil)
+	for _, variable := range outer {
+		t.Setenv(variable[0], variable[1])
+	}
+	t.Setenv(activeIdentityVar, "client")
+	if err := runIdentityShell(Identity{Name: "Bot", Email: "bot@example.com"}); err != nil {
+		t.Fatalf("nested runIdentityShell failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(output)
+	if got := string(content); got != "client|client@example.com\nbot@example.com|bot@example.com\n" {
+		t.Errorf("subshell output = %q", got)
+	}
+	if got := gitConfigValue(t, "--global", "user.email"); got != "me@example.com" {
+		t.Errorf("global user.email = %q after subshell, want it untouched", got)
+	}
+}
+
+func TestRunIdentityShellDropsOuterSSHKey(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	dir := t.TempDir()
+	output := filepath.Join(dir, "out")
+	shell := filepath.Join(dir, "fakeshell")
+	os.WriteFile(shell, []byte("#!/bin/sh\necho \"$GIT_SSH_COMMAND|$(git config core.sshCommand)\" > "+shellQuote(output)+"\n"), 0o755)
+	t.Setenv("SHELL", shell)
+
+	// Start inside a shell for an identity with a key.
+	work := Identity{Name: "Work", Email: "work@example.com", Nickname: "work", SSHKey: "/keys/work"}
+	outer, err := identityEnv(work, nil)
+	if err != nil {
+		t.Fatalf("identityEnv failed: %v", err)
+	}
+	for _, variable := range outer {
+		t.Setenv(variable[0], variable[1])
+	}
+	t.Setenv(activeIdentityVar, "work")
+
+	if err := runIdentityShell(Identity{Name: "Personal", Email: "me@example.com"}); err != nil {
+		t.Fatalf("nested runIdentityShell failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(output)
+	if got := string(content); got != "ssh|ssh\n" {
+		t.Errorf("keyless subshell saw ssh commands %q, want the outer key dropped", got)
+	}
+}
+
+func TestEnvCLIDefaultsToSh(t *testing.T) {
+	t.Setenv("SHELL", "/usr/bin/fish")
+	store := newMemoryStore(Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"})
+
+	r, w, _ := os.Pipe()
+	stdout := os.Stdout
+	os.Stdout = w
+	err := envCLI(store, []string{"work"})
+	os.Stdout = stdout
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("envCLI failed: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "export ") {
+		t.Errorf("env under fish without --format printed %q, want sh exports", out)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Exit codes of gitid. Wrapper scripts and hooks may rely on them, so a
+// code is never reused for another kind of failure. gitid exec exits with
+// the code of the command it ran instead.
+const (
+	ExitOK           = 0
+	ExitFailure      = 1
+	ExitNotFound     = 2
+	ExitAmbiguous    = 3
+	ExitNoIdentity   = 4
+	ExitGitMissing   = 5
+	ExitConfigLocked = 6
+	ExitInvalid      = 7
+	ExitPartialWrite = 8
+)
+
+// ambiguousError is returned when an identifier matches several
+// identities and none of them is an exact match, or matches a single one
+// too loosely to act on without asking.
+type ambiguousError struct {
+	identifier string
+	candidates []Identity
+}
+
+func (e *ambiguousError) Error() string {
+	var b strings.Builder
+	if len(e.candidates) == 1 {
+		fmt.Fprintf(&b, "%q only loosely matches:", e.identifier)
+	} else {
+		fmt.Fprintf(&b, "%q matches %d identities:", e.identifier, len(e.candidates))
+	}
+	for _, candidate := range e.candidates {
+		b.WriteString("\n  " + getIdentityDisplay(candidate))
+	}
+	return b.String()
+}
+
+// validationError marks an identity, key or value gitid refused before
+// writing anything.
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+func validationErrorf(format string, args ...any) error {
+	return &validationError{err: fmt.Errorf(format, args...)}
+}
+
+// partialWriteError is returned when a change failed after part of it was
+// already written, so the config is not where it was before the command.
+type partialWriteError struct {
+	done string
+	err  error
+}
+
+func (e *partialWriteError) Error() string {
+	return e.done + ", but " + e.err.Error()
+}
+
+func (e *partialWriteError) Unwrap() error {
+	return e.err
+}
+
+// exitStatus returns the exit code main reports for err.
+func exitStatus(err error) int {
+	var childErr *childExitError
+	var partialErr *partialWriteError
+	var ambiguousErr *ambiguousError
+	var validationErr *validationError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &childErr):
+		return childErr.code
+	case errors.As(err, &partialErr):
+		return ExitPartialWrite
+	case errors.Is(err, errConfigLocked):
+		return ExitConfigLocked
+	case errors.Is(err, errGitMissing):
+		return ExitGitMissing
+	case errors.As(err, &ambiguousErr):
+		return ExitAmbiguous
+	case errors.Is(err, errIdentityNotFound):
+		return ExitNotFound
+	case errors.Is(err, errNoIdentity):
+		return ExitNoIdentity
+	case errors.As(err, &validationErr):
+		return ExitInvalid
+	default:
+		return ExitFailure
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, ExitOK},
+		{"other", fmt.Errorf("error writing hook: permission denied"), ExitFailure},
+		{"not found", fmt.Errorf("%w: work", errIdentityNotFound), ExitNotFound},
+		{"ambiguous", &ambiguousError{identifier: "john"}, ExitAmbiguous},
+		{"no identity", errNoIdentity, ExitNoIdentity},
+		{"git missing", fmt.Errorf("error reading user.email: %w", errGitMissing), ExitGitMissing},
+		{"config locked", fmt.Errorf("error removing identity: %w", errConfigLocked), ExitConfigLocked},
+		{"invalid", fmt.Errorf("error updating identity: %w", validationErrorf("SSH key not found: x")), ExitInvalid},
+		{"partial write", &partialWriteError{done: "identity saved", err: errConfigLocked}, ExitPartialWrite},
+		{"json", &jsonError{err: errIdentityNotFound}, ExitNotFound},
+		{"child", &childExitError{code: 42}, 42},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := exitStatus(tt.err); code != tt.expected {
+				t.Errorf("exitStatus(%v) = %d, want %d", tt.err, code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCLIExitStatus(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	setupTestRepo(t)
+	defer func(timeout time.Duration) { configLockTimeout = timeout }(configLockTimeout)
+	configLockTimeout = 50 * time.Millisecond
+
+	store := gitConfigStore{}
+	addIdentity("Work User", "work@example.com", "work")
+	lock := filepath.Join(os.Getenv("HOME"), ".gitconfig.lock")
+
+	tests := []struct {
+		name     string
+		args     []string
+		locked   bool
+		expected int
+	}{
+		{"no identity configured", []string{"current"}, false, ExitNoIdentity},
+		{"unknown identity", []string{"switch", "nobody"}, false, ExitNotFound},
+		{"missing email", []string{"add", "No Email", ""}, false, ExitInvalid},
+		{"duplicate identity", []string{"add", "Work User", "work@example.com"}, false, ExitInvalid},
+		{"missing ssh key", []string{"sshkey", "work", "/nonexistent/id_ed25519"}, false, ExitInvalid},
+		{"locked config", []string{"switch", "work"}, true, ExitConfigLocked},
+		{"switch", []string{"switch", "work"}, false, ExitOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.locked {
+				os.WriteFile(lock, nil, 0o644)
+				defer os.Remove(lock)
+			}
+			if code := exitStatus(handleCLICommand(store, tt.args)); code != tt.expected {
+				t.Errorf("gitid %v exited with %d, want %d", tt.args, code, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Match tiers, best first: the query equals a field, starts it, appears
+// inside it, or only has its characters appear in it in order. A score is
+// tier*tierSize plus a bonus below tierSize that orders matches within a
+// tier.
+const (
+	tierSubsequence = 1
+	tierSubstring   = 2
+	tierPrefix      = 3
+	tierExact       = 4
+	tierSize        = 1000
+)
+
+func isWordStart(s []rune, i int) bool {
+	return i == 0 || !unicode.IsLetter(s[i-1]) && !unicode.IsDigit(s[i-1])
+}
+
+// fuzzyScore scores how well query matches s. Case is ignored unless query
+// has an upper-case letter, the way smart case works in editors. It is 0
+// when query does not match at all.
+func fuzzyScore(query, s string) int {
+	if strings.ToLower(query) == query {
+		s = strings.ToLower(s)
+	}
+	q, r := []rune(query), []rune(s)
+	extra := len(r) - len(q)
+	switch {
+	case len(q) == 0 || extra < 0:
+		return 0
+	case query == s:
+		return tierExact * tierSize
+	case strings.HasPrefix(s, query):
+		return tierPrefix*tierSize + 500 - min(extra, 499)
+	}
+
+	// Prefer an occurrence that starts a word, such as "smith" in
+	// "John Smith" or "oss" in "john@oss.org".
+	found, wordStart := -1, false
+	for offset := 0; ; {
+		i := strings.Index(s[offset:], query)
+		if i < 0 {
+			break
+		}
+		pos := utf8.RuneCountInString(s[:offset+i])
+		if found < 0 {
+			found = pos
+		}
+		if isWordStart(r, pos) {
+			found, wordStart = pos, true
+			break
+		}
+		offset += i + 1
+	}
+	if found >= 0 {
+		score := tierSubstring*tierSize + 500 - min(found, 200) - min(extra, 200)
+		if wordStart {
+			score += 250
+		}
+		return score
+	}
+
+	bonus, gaps, last := 0, 0, -1
+	for _, c := range q {
+		next := -1
+		for j := last + 1; j < len(r); j++ {
+			if r[j] == c {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			return 0
+		}
+		switch {
+		case next == last+1 && last >= 0:
+			bonus += 15
+		case isWordStart(r, next):
+			bonus += 10
+		}
+		if last >= 0 {
+			gaps += next - last - 1
+		}
+		last = next
+	}
+	return tierSubsequence*tierSize + 300 + min(bonus, 500) - min(gaps, 299)
+}
+
+// scoreIdentity returns the best score of query against the nickname,
+// email, name and tags of identity. Between equally good matches the
+// nickname wins, then the email, then the name, as they always have.
+func scoreIdentity(identity Identity, query string) int {
+	fields := append([]string{identity.Nickname, identity.Email, identity.Name}, identity.tagList()...)
+	best := 0
+	for i, field := range fields {
+		bonus := max(3-i, 0)
+		if score := fuzzyScore(query, field); score > 0 && score+bonus > best {
+			best = score + bonus
+		}
+	}
+	return best
+}
+
+// exactMatches returns the identities whose nickname is identifier, or
+// failing that whose email is, then whose name is, then which have it as a
+// tag. Strings are compared as they are, case included.
+func exactMatches(identities []Identity, identifier string) []Identity {
+	fields := []func(Identity) []string{
+		func(i Identity) []string { return []string{i.Nickname} },
+		func(i Identity) []string { return []string{i.Email} },
+		func(i Identity) []string { return []string{i.Name} },
+		Identity.tagList,
+	}
+	for _, field := range fields {
+		var matches []Identity
+		for _, identity := range identities {
+			if slices.Contains(field(identity), identifier) {
+				matches = append(matches, identity)
+			}
+		}
+		if len(matches) > 0 {
+			return matches
+		}
+	}
+	return nil
+}
+
+// rankIdentities returns the identities query matches, best first. Ties
+// keep the order of identities. An empty query matches every identity.
+func rankIdentities(identities []Identity, query string) []rankedIdentity {
+	var ranked []rankedIdentity
+	for _, identity := range identities {
+		score := 0
+		if query != "" {
+			if score = scoreIdentity(identity, query); score == 0 {
+				continue
+			}
+		}
+		ranked = append(ranked, rankedIdentity{Identity: identity, Score: score})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked
+}
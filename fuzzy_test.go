@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	tests := []struct {
+		query, s string
+		tier     int
+	}{
+		{"work", "work", tierExact},
+		{"work", "Work", tierExact},
+		{"Work", "work", 0},
+		{"Smith", "Goldsmith Co", 0},
+		{"john", "John Smith", tierPrefix},
+		{"smith", "John Smith", tierSubstring},
+		{"oss", "john@oss.org", tierSubstring},
+		{"jsm", "John Smith", tierSubsequence},
+		{"zzz", "John Smith", 0},
+		{"john smith jr", "John Smith", 0},
+		{"", "John Smith", 0},
+	}
+
+	for _, tt := range tests {
+		if got := fuzzyScore(tt.query, tt.s) / tierSize; got != tt.tier {
+			t.Errorf("fuzzyScore(%q, %q) is in tier %d, want %d", tt.query, tt.s, got, tt.tier)
+		}
+	}
+
+	// Within a tier, word starts, shorter fields and tighter matches win.
+	better := [][2][2]string{
+		{{"smith", "John Smith"}, {"smith", "Goldsmith Co"}},
+		{{"jo", "Jo"}, {"jo", "Johnny"}},
+		{{"jo", "John"}, {"jo", "Johnathan"}},
+		{{"jsm", "John Smith"}, {"jsm", "Jason Timms"}},
+	}
+	for _, pair := range better {
+		a, b := fuzzyScore(pair[0][0], pair[0][1]), fuzzyScore(pair[1][0], pair[1][1])
+		if a <= b {
+			t.Errorf("%q should match %q (%d) better than %q (%d)", pair[0][0], pair[0][1], a, pair[1][1], b)
+		}
+	}
+}
+
+func TestRankIdentities(t *testing.T) {
+	identities := []Identity{
+		{Name: "Goldsmith Co", Email: "billing@goldsmith.co"},
+		{Name: "John Smith", Email: "john@oss.org", Nickname: "oss"},
+		{Name: "Work User", Email: "smith@work.com", Tags: "acme,oncall"},
+		{Name: "Jane Doe", Email: "jane@example.com", Nickname: "smithy"},
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"smith", []string{"jane@example.com", "smith@work.com", "john@oss.org", "billing@goldsmith.co"}},
+		{"oss", []string{"john@oss.org"}},
+		{"acme", []string{"smith@work.com"}},
+		{"jsm", []string{"john@oss.org"}},
+		{"Smith", []string{"john@oss.org"}},
+		{"nobody", nil},
+		{"", []string{"billing@goldsmith.co", "john@oss.org", "smith@work.com", "jane@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var got []string
+			for _, candidate := range rankIdentities(identities, tt.query) {
+				got = append(got, candidate.Email)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("rankIdentities(%q) = %v, want %v", tt.query, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// errGitMissing is returned when the git binary cannot be found.
+var errGitMissing = errors.New("git not found in PATH")
+
+// gitError turns a failure to start git into errGitMissing and otherwise
+// returns fallback.
+func gitError(err, fallback error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return errGitMissing
+	}
+	return fallback
+}
+
+// gitVersion returns the major and minor version of the installed git.
+func gitVersion() (int, int, error) {
+	out, err := exec.Command("git", "version").Output()
+	if err != nil {
+		return 0, 0, gitError(err, fmt.Errorf("error running git version: %w", err))
+	}
+	return parseGitVersion(string(out))
+}
+
+// parseGitVersion parses output such as "git version 2.39.5" or
+// "git version 2.37.1 (Apple Git-137.1)".
+func parseGitVersion(out string) (int, int, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 3 || fields[0] != "git" || fields[1] != "version" {
+		return 0, 0, fmt.Errorf("unexpected git version output: %q", strings.TrimSpace(out))
+	}
+
+	parts := strings.SplitN(fields[2], ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("unexpected git version: %q", fields[2])
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected git version: %q", fields[2])
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("unexpected git version: %q", fields[2])
+	}
+	return major, minor, nil
+}
+
+func requireGitVersion(major, minor int, feature string) error {
+	haveMajor, haveMinor, err := gitVersion()
+	if err != nil {
+		return err
+	}
+	if haveMajor > major || (haveMajor == major && haveMinor >= minor) {
+		return nil
+	}
+	return fmt.Errorf("%s require git %d.%d or newer (installed: %d.%d)", feature, major, minor, haveMajor, haveMinor)
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestParseGitVersion(t *testing.T) {
+	tests := []struct {
+		input   string
+		major   int
+		minor   int
+		wantErr bool
+	}{
+		{"git version 2.39.5\n", 2, 39, false},
+		{"git version 2.37.1 (Apple Git-137.1)", 2, 37, false},
+		{"git version 2.45.2.windows.1", 2, 45, false},
+		{"not git", 0, 0, true},
+		{"git version x.y", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			major, minor, err := parseGitVersion(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseGitVersion(%q) should fail", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGitVersion(%q) failed: %v", tt.input, err)
+			}
+			if major != tt.major || minor != tt.minor {
+				t.Errorf("parseGitVersion(%q) = %d.%d, want %d.%d", tt.input, major, minor, tt.major, tt.minor)
+			}
+		})
+	}
+}
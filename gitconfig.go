@@ -0,0 +1,826 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxIncludeDepth matches the limit git puts on nested includes.
+const maxIncludeDepth = 10
+
+// parseConfigFile parses content in git's config format. The parsed
+// sections and variables keep their byte offsets into content, so edits
+// can splice content and leave everything else, comments included, as it
+// was.
+func parseConfigFile(path, content string) (*configFile, error) {
+	f := &configFile{path: path, content: content}
+	p := configParser{file: f}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readConfigFile parses the config file at path. A missing file parses as
+// an empty one.
+func readConfigFile(path string) (*configFile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return parseConfigFile(path, string(content))
+}
+
+type configParser struct {
+	file      *configFile
+	pos       int
+	line      int
+	lineStart int
+}
+
+func (p *configParser) errorf() error {
+	name := p.file.path
+	if name == "" {
+		name = "blob"
+	}
+	return fmt.Errorf("bad config line %d in file %s", p.line+1, name)
+}
+
+// next returns the next character, folding "\r\n" into "\n", or -1 at
+// the end of content.
+func (p *configParser) next() int {
+	content := p.file.content
+	if p.pos >= len(content) {
+		p.pos++
+		return -1
+	}
+	c := content[p.pos]
+	p.pos++
+	if c == '\r' && p.pos < len(content) && content[p.pos] == '\n' {
+		c = '\n'
+		p.pos++
+	}
+	if c == '\n' {
+		p.line++
+		p.lineStart = p.pos
+	}
+	return int(c)
+}
+
+func isConfigSpace(c int) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isKeyChar(c int) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+func (p *configParser) parse() error {
+	section := -1
+	for {
+		lineStart := p.lineStart
+		c := p.next()
+		switch {
+		case c < 0:
+			return nil
+		case isConfigSpace(c):
+		case c == '#' || c == ';':
+			for c != '\n' && c >= 0 {
+				c = p.next()
+			}
+		case c == '[':
+			header, err := p.parseHeader(lineStart)
+			if err != nil {
+				return err
+			}
+			p.file.sections = append(p.file.sections, header)
+			section = len(p.file.sections) - 1
+		case isKeyChar(c) && c != '-' && (c < '0' || c > '9'):
+			if section < 0 {
+				return p.errorf()
+			}
+			v, err := p.parseVar(section, lineStart, p.pos-1)
+			if err != nil {
+				return err
+			}
+			p.file.vars = append(p.file.vars, v)
+		default:
+			return p.errorf()
+		}
+	}
+}
+
+// parseHeader parses a section header after its "[". Both the quoted
+// subsection form and the deprecated [section.subsection] form are read.
+func (p *configParser) parseHeader(lineStart int) (configSection, error) {
+	header := configSection{start: lineStart}
+	var name strings.Builder
+	for {
+		c := p.next()
+		switch {
+		case c == ']':
+			header.name = name.String()
+			if section, subsection, found := strings.Cut(header.name, "."); found {
+				header.name, header.subsection, header.hasSubsection = section, subsection, true
+			}
+			header.end = p.pos
+			return header, nil
+		case c == ' ' || c == '\t':
+			header.name = name.String()
+			subsection, err := p.parseSubsection()
+			if err != nil {
+				return header, err
+			}
+			header.subsection, header.hasSubsection = subsection, true
+			header.end = p.pos
+			return header, nil
+		case isKeyChar(c) || c == '.':
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			name.WriteByte(byte(c))
+		default:
+			return header, p.errorf()
+		}
+	}
+}
+
+func (p *configParser) parseSubsection() (string, error) {
+	c := p.next()
+	for c == ' ' || c == '\t' {
+		c = p.next()
+	}
+	if c != '"' {
+		return "", p.errorf()
+	}
+
+	var subsection strings.Builder
+	for {
+		c = p.next()
+		switch c {
+		case '\n', -1:
+			return "", p.errorf()
+		case '"':
+			if p.next() != ']' {
+				return "", p.errorf()
+			}
+			return subsection.String(), nil
+		case '\\':
+			c = p.next()
+			if c == '\n' || c < 0 {
+				return "", p.errorf()
+			}
+		}
+		subsection.WriteByte(byte(c))
+	}
+}
+
+// parseVar parses a variable starting at nameStart, up to and including
+// the end of its (possibly continued) line.
+func (p *configParser) parseVar(section, lineStart, nameStart int) (configVar, error) {
+	v := configVar{section: section, start: nameStart}
+	if strings.TrimLeft(p.file.content[lineStart:nameStart], " \t") == "" {
+		v.start = lineStart
+	}
+
+	// The name ends before the character that stops it, which next may
+	// have read as two bytes when it is a CRLF line ending.
+	nameEnd := p.pos
+	c := p.next()
+	for isKeyChar(c) {
+		nameEnd = p.pos
+		c = p.next()
+	}
+	v.name = strings.ToLower(p.file.content[nameStart:nameEnd])
+	for c == ' ' || c == '\t' {
+		c = p.next()
+	}
+
+	switch c {
+	case '\n', -1:
+		v.implicit = true
+	case '=':
+		value, err := p.parseValue()
+		if err != nil {
+			return v, err
+		}
+		v.value = value
+	default:
+		return v, p.errorf()
+	}
+	v.end = min(p.pos, len(p.file.content))
+	return v, nil
+}
+
+// parseValue follows git's parse_value: whitespace outside quotes is kept
+// as single spaces between words, comments end the value, and only the
+// \n, \t, \b, \" and \\ escapes are allowed.
+func (p *configParser) parseValue() (string, error) {
+	var value strings.Builder
+	quote, comment := false, false
+	space := 0
+	for {
+		c := p.next()
+		if c == '\n' || c < 0 {
+			if quote {
+				return "", p.errorf()
+			}
+			return value.String(), nil
+		}
+		if comment {
+			continue
+		}
+		if isConfigSpace(c) && !quote {
+			if value.Len() > 0 {
+				space++
+			}
+			continue
+		}
+		if !quote && (c == ';' || c == '#') {
+			comment = true
+			continue
+		}
+		for ; space > 0; space-- {
+			value.WriteByte(' ')
+		}
+		switch c {
+		case '\\':
+			switch c = p.next(); c {
+			case '\n':
+				continue
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'n':
+				c = '\n'
+			case '\\', '"':
+			default:
+				return "", p.errorf()
+			}
+		case '"':
+			quote = !quote
+			continue
+		}
+		value.WriteByte(byte(c))
+	}
+}
+
+// splitConfigKey splits a key such as includeIf.gitdir:~/work/.path into
+// its section, subsection and variable name, keeping the case the caller
+// wrote them in.
+func splitConfigKey(key string) (section, subsection, name string, hasSubsection bool, err error) {
+	first, last := strings.Index(key, "."), strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", false, fmt.Errorf("key does not contain a section: %s", key)
+	}
+	section, name = key[:first], key[last+1:]
+	if first != last {
+		subsection, hasSubsection = key[first+1:last], true
+	}
+	return section, subsection, name, hasSubsection, nil
+}
+
+func (f *configFile) key(v configVar) string {
+	s := f.sections[v.section]
+	if s.hasSubsection {
+		return s.name + "." + s.subsection + "." + v.name
+	}
+	return s.name + "." + v.name
+}
+
+func (f *configFile) matches(v configVar, section, subsection, name string, hasSubsection bool) bool {
+	s := f.sections[v.section]
+	return strings.EqualFold(s.name, section) && s.hasSubsection == hasSubsection &&
+		s.subsection == subsection && strings.EqualFold(v.name, name)
+}
+
+// lookup returns the indexes of the variables set for key.
+func (f *configFile) lookup(key string) ([]int, error) {
+	section, subsection, name, hasSubsection, err := splitConfigKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var found []int
+	for i, v := range f.vars {
+		if f.matches(v, section, subsection, name, hasSubsection) {
+			found = append(found, i)
+		}
+	}
+	return found, nil
+}
+
+// entries returns every variable of f, without following includes.
+func (f *configFile) entries() []configEntry {
+	entries := make([]configEntry, 0, len(f.vars))
+	for _, v := range f.vars {
+		entries = append(entries, configEntry{Key: f.key(v), Value: v.value, Implicit: v.implicit, Origin: f.path})
+	}
+	return entries
+}
+
+// get returns the last value of key, the one git uses.
+func (f *configFile) get(key string) (string, bool) {
+	found, err := f.lookup(key)
+	if err != nil || len(found) == 0 {
+		return "", false
+	}
+	return f.vars[found[len(found)-1]].value, true
+}
+
+// quoteConfigValue quotes value the way git writes it, so it reads back
+// unchanged.
+func quoteConfigValue(value string) string {
+	quote := strings.HasPrefix(value, " ") || strings.HasSuffix(value, " ") || strings.ContainsAny(value, ";#")
+	var b strings.Builder
+	if quote {
+		b.WriteByte('"')
+	}
+	for _, c := range []byte(value) {
+		switch c {
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if quote {
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func formatConfigHeader(section, subsection string, hasSubsection bool) string {
+	if !hasSubsection {
+		return "[" + section + "]\n"
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+	return "[" + section + " \"" + escaped + "\"]\n"
+}
+
+// splice replaces content[start:end] with text and parses the result.
+func (f *configFile) splice(start, end int, text string) error {
+	content := f.content[:start] + text + f.content[end:]
+	parsed, err := parseConfigFile(f.path, content)
+	if err != nil {
+		return err
+	}
+	*f = *parsed
+	return nil
+}
+
+// varText formats name = value for a variable at pos: on a line of its
+// own, unless it replaces one that shares a line with its header.
+func (f *configFile) varText(pos int, name, value string) string {
+	text := "\t" + name + " = " + quoteConfigValue(value) + "\n"
+	if pos > 0 && f.content[pos-1] != '\n' {
+		text = "\n" + text
+	}
+	return text
+}
+
+// set replaces the single value of key, or adds it when key is not set.
+// Like git, it refuses to overwrite a key that has several values.
+func (f *configFile) set(key, value string) error {
+	found, err := f.lookup(key)
+	if err != nil {
+		return err
+	}
+	switch len(found) {
+	case 0:
+		return f.add(key, value)
+	case 1:
+		return f.replace(found[0], key, value)
+	default:
+		return fmt.Errorf("cannot overwrite multiple values of %s with a single value", key)
+	}
+}
+
+func (f *configFile) replace(i int, key, value string) error {
+	_, _, name, _, _ := splitConfigKey(key)
+	v := f.vars[i]
+	if v.start == 0 || f.content[v.start-1] == '\n' {
+		return f.splice(v.start, v.end, f.varText(v.start, name, value))
+	}
+	// The variable follows its header on the same line.
+	return f.splice(v.start, v.end, name+" = "+quoteConfigValue(value)+"\n")
+}
+
+// add appends a value to key: after its last value, else at the end of
+// the last section it belongs in, else in a new section at the end.
+func (f *configFile) add(key, value string) error {
+	section, subsection, name, hasSubsection, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	pos := -1
+	for i, s := range f.sections {
+		if strings.EqualFold(s.name, section) && s.hasSubsection == hasSubsection && s.subsection == subsection {
+			pos = f.sectionEnd(i)
+		}
+	}
+	if found, _ := f.lookup(key); len(found) > 0 {
+		pos = f.vars[found[len(found)-1]].end
+	}
+	if pos >= 0 {
+		return f.splice(pos, pos, f.varText(pos, name, value))
+	}
+
+	pos = len(f.content)
+	text := formatConfigHeader(section, subsection, hasSubsection) + "\t" + name + " = " + quoteConfigValue(value) + "\n"
+	if pos > 0 && f.content[pos-1] != '\n' {
+		text = "\n" + text
+	}
+	return f.splice(pos, pos, text)
+}
+
+// sectionEnd returns where a variable appended to section i goes: after
+// its last variable, or after the header line.
+func (f *configFile) sectionEnd(i int) int {
+	end := -1
+	for _, v := range f.vars {
+		if v.section == i {
+			end = v.end
+		}
+	}
+	if end >= 0 {
+		return end
+	}
+	end = f.sections[i].end
+	if newline := strings.IndexByte(f.content[end:], '\n'); newline >= 0 {
+		return end + newline + 1
+	}
+	return len(f.content)
+}
+
+// unset removes every value of key and returns how many there were.
+func (f *configFile) unset(key string) (int, error) {
+	found, err := f.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return f.remove(found)
+}
+
+// unsetValue removes the values of key equal to value, like git config
+// --fixed-value --unset-all, and returns how many there were.
+func (f *configFile) unsetValue(key, value string) (int, error) {
+	found, err := f.lookupValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return f.remove(found)
+}
+
+// replaceValue replaces the values of key equal to old with a single
+// value, like git config --fixed-value --replace-all. It adds value when
+// none matches.
+func (f *configFile) replaceValue(key, old, value string) error {
+	found, err := f.lookupValue(key, old)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return f.add(key, value)
+	}
+	if err := f.replace(found[len(found)-1], key, value); err != nil {
+		return err
+	}
+	_, err = f.remove(found[:len(found)-1])
+	return err
+}
+
+func (f *configFile) lookupValue(key, value string) ([]int, error) {
+	found, err := f.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	matching := found[:0]
+	for _, i := range found {
+		if f.vars[i].value == value {
+			matching = append(matching, i)
+		}
+	}
+	return matching, nil
+}
+
+// remove deletes the variables at the given ascending indexes.
+func (f *configFile) remove(found []int) (int, error) {
+	removed := 0
+	for i := len(found) - 1; i >= 0; i-- {
+		v := f.vars[found[i]]
+		if err := f.splice(v.start, v.end, ""); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// removeSection removes every section named name, with all of its
+// variables and the comments inside it.
+func (f *configFile) removeSection(name string) error {
+	section, subsection, hasSubsection := name, "", false
+	if first := strings.Index(name, "."); first >= 0 {
+		section, subsection, hasSubsection = name[:first], name[first+1:], true
+	}
+
+	removed := false
+	for i := len(f.sections) - 1; i >= 0; i-- {
+		s := f.sections[i]
+		if !strings.EqualFold(s.name, section) || s.hasSubsection != hasSubsection || s.subsection != subsection {
+			continue
+		}
+		end := len(f.content)
+		if i+1 < len(f.sections) {
+			end = f.sections[i+1].start
+		}
+		if err := f.splice(s.start, end, ""); err != nil {
+			return err
+		}
+		removed = true
+	}
+	if !removed {
+		return fmt.Errorf("no such section: %s", name)
+	}
+	return nil
+}
+
+// configLockTimeout is how long a writer waits for another process to
+// release a config file's lock before giving up.
+var configLockTimeout = 2 * time.Second
+
+// errConfigLocked is returned when a config file stays locked by another
+// process for longer than configLockTimeout.
+var errConfigLocked = errors.New("config file is locked by another process")
+
+// lockConfigFile takes git's lock on the config file at path by creating
+// path.lock, retrying while another process holds it.
+func lockConfigFile(path string, mode os.FileMode) (*os.File, error) {
+	deadline := time.Now().Add(configLockTimeout)
+	for {
+		lock, err := os.OpenFile(path+".lock", os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err == nil {
+			return lock, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("could not lock config file %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %s.lock exists", errConfigLocked, path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// updateConfigFile applies change to the config file at path as one
+// transaction. The file is locked the way git locks it, read under the
+// lock so concurrent writers cannot lose each other's changes, and
+// replaced in a single rename. When change fails nothing is written and
+// the file is left exactly as it was. Symlinks are followed, as git does.
+func updateConfigFile(path string, change func(*configFile) error) error {
+	return updateConfigFiles([]string{path}, func(files []*configFile) error {
+		return change(files[0])
+	})
+}
+
+// lockedConfigFile is a config file held under its lock during a
+// transaction, with the content it had when it was read.
+type lockedConfigFile struct {
+	file   *configFile
+	lock   *os.File
+	mode   os.FileMode
+	before string
+}
+
+// updateConfigFiles is updateConfigFile for several files at once. Every
+// file is locked and read before change runs, and none is written unless
+// change succeeds for all of them. change gets the files in the order of
+// paths; paths naming the same file share one *configFile. Locks are taken
+// in a fixed order so two transactions cannot wait on each other.
+func updateConfigFiles(paths []string, change func([]*configFile) error) error {
+	resolved := make([]string, len(paths))
+	for i, path := range paths {
+		resolved[i] = path
+		if target, err := filepath.EvalSymlinks(path); err == nil {
+			resolved[i] = target
+		}
+	}
+	order := slices.Clone(resolved)
+	slices.Sort(order)
+	order = slices.Compact(order)
+
+	held := make(map[string]*lockedConfigFile)
+	defer func() {
+		for _, l := range held {
+			if l.lock != nil {
+				l.lock.Close()
+				os.Remove(l.lock.Name())
+			}
+		}
+	}()
+	for _, path := range order {
+		mode := os.FileMode(0o644)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		lock, err := lockConfigFile(path, mode)
+		if err != nil {
+			return err
+		}
+		l := &lockedConfigFile{lock: lock, mode: mode}
+		held[path] = l
+		if l.file, err = readConfigFile(path); err != nil {
+			return err
+		}
+		l.before = l.file.content
+	}
+
+	files := make([]*configFile, len(paths))
+	for i, path := range resolved {
+		files[i] = held[path].file
+	}
+	if err := change(files); err != nil {
+		return err
+	}
+
+	var changed []string
+	for _, path := range order {
+		l := held[path]
+		if l.file.content == l.before {
+			continue
+		}
+		if _, err := l.lock.WriteString(l.file.content); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		if err := l.lock.Sync(); err != nil {
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		changed = append(changed, path)
+	}
+	for i, path := range changed {
+		l := held[path]
+		err := l.lock.Close()
+		if err == nil {
+			err = os.Rename(l.lock.Name(), path)
+		}
+		if err != nil {
+			// Put back the files already replaced, so that the
+			// transaction still applies all or nothing.
+			for _, done := range changed[:i] {
+				restoreConfigFile(done, held[done].mode, held[done].before)
+			}
+			return fmt.Errorf("error writing %s: %w", path, err)
+		}
+		l.lock = nil
+	}
+	return nil
+}
+
+// restoreConfigFile writes content back to the config file at path under
+// its lock after a transaction failed halfway.
+func restoreConfigFile(path string, mode os.FileMode, content string) {
+	lock, err := lockConfigFile(path, mode)
+	if err != nil {
+		return
+	}
+	if _, err := lock.WriteString(content); err != nil || lock.Close() != nil {
+		lock.Close()
+		os.Remove(lock.Name())
+		return
+	}
+	if os.Rename(lock.Name(), path) != nil {
+		os.Remove(lock.Name())
+	}
+}
+
+// readConfigEntries returns the variables of the file at path with its
+// includes expanded in place. Conditional includes are evaluated against
+// ctx; with a nil ctx only unconditional ones are followed.
+func readConfigEntries(path string, ctx *includeContext) ([]configEntry, error) {
+	return readConfigEntriesDepth(path, ctx, 0)
+}
+
+func readConfigEntriesDepth(path string, ctx *includeContext, depth int) ([]configEntry, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("exceeded maximum include depth (%d) at %s", maxIncludeDepth, path)
+	}
+	f, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []configEntry
+	for _, entry := range f.entries() {
+		entries = append(entries, entry)
+		include, ok := includePath(entry, ctx)
+		if !ok {
+			continue
+		}
+		include, err := resolveIncludePath(include, path)
+		if err != nil {
+			return nil, err
+		}
+		included, err := readConfigEntriesDepth(include, ctx, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, included...)
+	}
+	return entries, nil
+}
+
+// includePath reports whether entry includes another file under ctx.
+func includePath(entry configEntry, ctx *includeContext) (string, bool) {
+	if entry.Implicit {
+		return "", false
+	}
+	if entry.Key == "include.path" {
+		return entry.Value, true
+	}
+	condition, found := strings.CutPrefix(entry.Key, "includeif.")
+	if !found || !strings.HasSuffix(condition, ".path") || ctx == nil {
+		return "", false
+	}
+	condition = strings.TrimSuffix(condition, ".path")
+	return entry.Value, ctx.matches(condition, entry.Origin)
+}
+
+func resolveIncludePath(include, from string) (string, error) {
+	include, err := expandHome(include)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(filepath.Dir(from), include)
+	}
+	return include, nil
+}
+
+// matches evaluates an includeIf condition the way git does.
+func (ctx *includeContext) matches(condition, from string) bool {
+	switch {
+	case strings.HasPrefix(condition, "gitdir:"):
+		return ctx.matchesGitdir(strings.TrimPrefix(condition, "gitdir:"), from, false)
+	case strings.HasPrefix(condition, "gitdir/i:"):
+		return ctx.matchesGitdir(strings.TrimPrefix(condition, "gitdir/i:"), from, true)
+	case strings.HasPrefix(condition, "onbranch:"):
+		return ctx.branch != "" && wildmatch(strings.TrimPrefix(condition, "onbranch:"), ctx.branch)
+	case strings.HasPrefix(condition, "hasconfig:remote.*.url:"):
+		pattern := strings.TrimPrefix(condition, "hasconfig:remote.*.url:")
+		for _, url := range ctx.remoteURLs {
+			if wildmatch(pattern, url) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ctx *includeContext) matchesGitdir(pattern, from string, fold bool) bool {
+	if ctx.gitDir == "" {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(pattern, "~/"):
+		expanded, err := expandHome(pattern)
+		if err != nil {
+			return false
+		}
+		pattern = filepath.ToSlash(expanded) + strings.Repeat("/", len(pattern)-len(strings.TrimRight(pattern, "/")))
+	case strings.HasPrefix(pattern, "./"):
+		pattern = filepath.ToSlash(filepath.Dir(from)) + pattern[1:]
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**/") {
+		pattern = "**/" + pattern
+	}
+
+	candidates := []string{ctx.gitDir}
+	if resolved, err := filepath.EvalSymlinks(ctx.gitDir); err == nil {
+		candidates = append(candidates, resolved)
+	}
+	for _, gitDir := range candidates {
+		gitDir = filepath.ToSlash(gitDir)
+		if fold {
+			if wildmatch(strings.ToLower(pattern), strings.ToLower(gitDir)) {
+				return true
+			}
+		} else if wildmatch(pattern, gitDir) {
+			return true
+		}
+	}
+	return false
+}
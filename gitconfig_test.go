@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func configFixtures(t *testing.T) []string {
+	t.Helper()
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "gitconfig", "*.gitconfig"))
+	if err != nil || len(fixtures) == 0 {
+		t.Fatalf("no config fixtures found: %v", err)
+	}
+	return fixtures
+}
+
+// gitConfigList lists the variables of path as git reads them. It runs
+// outside any repository so that no conditional include applies.
+func gitConfigList(t *testing.T, path string, includes bool) []configEntry {
+	t.Helper()
+	path, _ = filepath.Abs(path)
+	args := []string{"config", "--file", path, "--null", "--list"}
+	if includes {
+		args = append(args, "--includes")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = t.TempDir()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git config --list %s failed: %v", path, err)
+	}
+
+	var entries []configEntry
+	for _, entry := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		key, value, found := strings.Cut(entry, "\n")
+		entries = append(entries, configEntry{Key: key, Value: value, Implicit: !found})
+	}
+	return entries
+}
+
+func withoutOrigins(entries []configEntry) []configEntry {
+	stripped := make([]configEntry, len(entries))
+	for i, entry := range entries {
+		entry.Origin = ""
+		stripped[i] = entry
+	}
+	return stripped
+}
+
+func TestParseConfigMatchesGit(t *testing.T) {
+	for _, fixture := range configFixtures(t) {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			f, err := readConfigFile(fixture)
+			if err != nil {
+				t.Fatalf("readConfigFile failed: %v", err)
+			}
+			if got, want := withoutOrigins(f.entries()), gitConfigList(t, fixture, false); !reflect.DeepEqual(got, want) {
+				t.Errorf("entries = %+v\nwant %+v", got, want)
+			}
+
+			included, err := readConfigEntries(fixture, nil)
+			if err != nil {
+				t.Fatalf("readConfigEntries failed: %v", err)
+			}
+			if got, want := withoutOrigins(included), gitConfigList(t, fixture, true); !reflect.DeepEqual(got, want) {
+				t.Errorf("entries with includes = %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []string{
+		"key = outside any section\n",
+		"[section\n",
+		"[section \"unterminated]\n",
+		"[section]\n\tkey = \"unterminated\n",
+		"[section]\n\tkey = bad \\q escape\n",
+		"[section]\n\t1key = digit first\n",
+	}
+	for _, content := range tests {
+		if _, err := parseConfigFile("test", content); err == nil {
+			t.Errorf("parseConfigFile(%q) should fail", content)
+		}
+	}
+}
+
+// configEdits are applied to every fixture both natively and through git.
+var configEdits = []struct {
+	name  string
+	args  []string
+	apply func(*configFile) error
+}{
+	{"set existing", []string{"user.name", "Edited Name"}, func(f *configFile) error { return f.set("user.name", "Edited Name") }},
+	{"set new key in section", []string{"core.pager", "cat"}, func(f *configFile) error { return f.set("core.pager", "cat") }},
+	{"set new section", []string{"identity.0123456789ab.name", "New Identity"}, func(f *configFile) error {
+		return f.set("identity.0123456789ab.name", "New Identity")
+	}},
+	{"set special value", []string{"gitid.note", " padded; with # and \"quotes\"\nand a newline\t"}, func(f *configFile) error {
+		return f.set("gitid.note", " padded; with # and \"quotes\"\nand a newline\t")
+	}},
+	{"add", []string{"--add", "user.email", "second@example.com"}, func(f *configFile) error { return f.add("user.email", "second@example.com") }},
+	{"unset all", []string{"--unset-all", "core.editor"}, func(f *configFile) error {
+		removed, err := f.unset("core.editor")
+		if err == nil && removed == 0 {
+			// git exits 5 when the key was not set.
+			return errors.New("key not set")
+		}
+		return err
+	}},
+	{"remove section", []string{"--remove-section", "user"}, func(f *configFile) error { return f.removeSection("user") }},
+}
+
+func TestEditConfigMatchesGit(t *testing.T) {
+	for _, fixture := range configFixtures(t) {
+		original, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, edit := range configEdits {
+			t.Run(filepath.Base(fixture)+"/"+edit.name, func(t *testing.T) {
+				dir := t.TempDir()
+				native, viaGit := filepath.Join(dir, "native"), filepath.Join(dir, "git")
+				os.WriteFile(native, original, 0o644)
+				os.WriteFile(viaGit, original, 0o644)
+
+				nativeErr := updateConfigFile(native, edit.apply)
+				gitErr := exec.Command("git", append([]string{"config", "--file", viaGit}, edit.args...)...).Run()
+				if (nativeErr == nil) != (gitErr == nil) {
+					t.Fatalf("native error %v, git error %v", nativeErr, gitErr)
+				}
+
+				if got, want := gitConfigList(t, native, false), gitConfigList(t, viaGit, false); !reflect.DeepEqual(got, want) {
+					t.Errorf("after edit git reads %+v\nwant %+v", got, want)
+				}
+
+				// Comments git keeps must survive the native edit too.
+				written, _ := os.ReadFile(native)
+				expected, _ := os.ReadFile(viaGit)
+				for _, line := range strings.Split(string(expected), "\n") {
+					if comment := strings.TrimSpace(line); strings.HasPrefix(comment, "#") && !strings.Contains(string(written), comment) {
+						t.Errorf("comment %q was lost", comment)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestUpdateConfigRespectsLock(t *testing.T) {
+	defer func(timeout time.Duration) { configLockTimeout = timeout }(configLockTimeout)
+	configLockTimeout = 50 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(path, []byte("[user]\n\tname = Locked\n"), 0o644)
+	os.WriteFile(path+".lock", nil, 0o644)
+
+	err := updateConfigFile(path, func(f *configFile) error { return f.set("user.name", "Changed") })
+	if !errors.Is(err, errConfigLocked) {
+		t.Errorf("update of a locked file returned %v, want errConfigLocked", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "[user]\n\tname = Locked\n" {
+		t.Errorf("locked file was changed to %q", content)
+	}
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Error("the other process's lock file should be left alone")
+	}
+}
+
+func TestUpdateConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	os.WriteFile(a, []byte("[user]\n\tname = A\n"), 0o644)
+	os.WriteFile(b, []byte("[user]\n\tname = B\n"), 0o644)
+
+	failure := errors.New("second file failed")
+	err := updateConfigFiles([]string{a, b}, func(files []*configFile) error {
+		files[0].set("user.name", "Changed")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("update returned %v, want the change's error", err)
+	}
+	if content, _ := os.ReadFile(a); string(content) != "[user]\n\tname = A\n" {
+		t.Errorf("failed transaction changed %q", content)
+	}
+
+	err = updateConfigFiles([]string{a, b, a}, func(files []*configFile) error {
+		if files[0] != files[2] {
+			t.Error("paths naming the same file should share it")
+		}
+		files[0].set("user.name", "New A")
+		return files[1].set("user.name", "New B")
+	})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	for path, want := range map[string]string{a: "New A", b: "New B"} {
+		if f, _ := readConfigFile(path); f == nil || f.vars[0].value != want {
+			t.Errorf("%s was not updated to %s", filepath.Base(path), want)
+		}
+		if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+			t.Errorf("%s is still locked", filepath.Base(path))
+		}
+	}
+}
+
+func TestUpdateConfigWaitsForLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	os.WriteFile(path+".lock", nil, 0o644)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		os.Remove(path + ".lock")
+	}()
+
+	if err := updateConfigFile(path, func(f *configFile) error { return f.set("user.name", "Waited") }); err != nil {
+		t.Fatalf("update should succeed once the lock is released: %v", err)
+	}
+	if f, _ := readConfigFile(path); f == nil || f.vars[0].value != "Waited" {
+		t.Error("the update was not written after waiting for the lock")
+	}
+}
+
+func TestUpdateConfigRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	original := "# keep me\n[user]\n\tname = Original\n"
+	os.WriteFile(path, []byte(original), 0o600)
+
+	failure := errors.New("third write failed")
+	err := updateConfigFile(path, func(f *configFile) error {
+		f.set("user.name", "Changed")
+		f.set("user.email", "changed@example.com")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("update returned %v, want the change's error", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != original {
+		t.Errorf("failed update left %q, want the original content", content)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("a failed update should release its lock")
+	}
+
+	updateConfigFile(path, func(f *configFile) error { return f.set("user.name", "Changed") })
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("update should keep the file mode, got %v", info.Mode())
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (k SecretKey) expired(now time.Time) bool {
+	return k.Validity == "e" || (!k.Expires.IsZero() && k.Expires.Before(now))
+}
+
+// normalizeKeyID returns id in upper case without the "0x" prefix and "!"
+// suffix gpg accepts, and reports whether it is a 16-digit long key ID or
+// a full fingerprint. Shorter IDs are refused: they collide too easily to
+// tell which key git would sign with.
+func normalizeKeyID(id string) (string, bool) {
+	id = strings.ToUpper(strings.TrimSuffix(strings.TrimPrefix(id, "0x"), "!"))
+	if len(id) != 16 && len(id) != 40 && len(id) != 64 {
+		return id, false
+	}
+	_, err := hex.DecodeString(id)
+	return id, err == nil
+}
+
+// matches reports whether id, as returned by normalizeKeyID, is the key ID
+// or fingerprint of the key or one of its subkeys.
+func (k SecretKey) matches(id string) bool {
+	for _, fpr := range append([]string{k.Fingerprint, k.KeyID}, k.Subkeys...) {
+		fpr = strings.ToUpper(fpr)
+		if fpr == "" {
+			continue
+		}
+		if fpr == id || len(id) == 16 && strings.HasSuffix(fpr, id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k SecretKey) hasEmail(email string) bool {
+	for _, uid := range k.UIDs {
+		if strings.EqualFold(uidEmail(uid), email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k SecretKey) String() string {
+	uid := ""
+	if len(k.UIDs) > 0 {
+		uid = k.UIDs[0]
+	}
+	return fmt.Sprintf("%s %s", k.Fingerprint, uid)
+}
+
+// uidEmail extracts the address from a user ID such as
+// "Jane Doe <jane@example.com>". A bare address is returned as is.
+func uidEmail(uid string) string {
+	if start := strings.LastIndex(uid, "<"); start >= 0 {
+		if end := strings.Index(uid[start:], ">"); end > 0 {
+			return uid[start+1 : start+end]
+		}
+	}
+	return strings.TrimSpace(uid)
+}
+
+func keyringProgram(format string) string {
+	if format == SigningX509 {
+		return "gpgsm"
+	}
+	return "gpg"
+}
+
+// listSecretKeys returns the secret keys gpg (or gpgsm for x509) can use.
+func listSecretKeys(format string) ([]SecretKey, error) {
+	program := keyringProgram(format)
+	if _, err := exec.LookPath(program); err != nil {
+		return nil, fmt.Errorf("%s not found in PATH; install GnuPG to sign with %s keys", program, format)
+	}
+
+	out, err := exec.Command(program, "--list-secret-keys", "--with-colons").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("error listing secret keys: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("error listing secret keys: %w", err)
+	}
+	return parseSecretKeys(string(out)), nil
+}
+
+// parseSecretKeys parses the --with-colons listing of gpg and gpgsm.
+func parseSecretKeys(out string) []SecretKey {
+	var keys []SecretKey
+	var current *SecretKey
+	inSubkey := false
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 10 {
+			continue
+		}
+
+		switch fields[0] {
+		case "sec", "crs":
+			keys = append(keys, SecretKey{KeyID: fields[4], Validity: fields[1]})
+			current = &keys[len(keys)-1]
+			inSubkey = false
+			if expires, err := strconv.ParseInt(fields[6], 10, 64); err == nil && expires > 0 {
+				current.Expires = time.Unix(expires, 0)
+			}
+		case "ssb":
+			inSubkey = true
+		case "fpr":
+			if current == nil {
+				continue
+			}
+			if inSubkey {
+				current.Subkeys = append(current.Subkeys, fields[9])
+			} else if current.Fingerprint == "" {
+				current.Fingerprint = fields[9]
+			}
+		case "uid":
+			if current != nil {
+				current.UIDs = append(current.UIDs, unescapeColons(fields[9]))
+			}
+		}
+	}
+	return keys
+}
+
+// unescapeColons decodes the \xHH escapes used in colon listings.
+func unescapeColons(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// checkSecretKey verifies that id names a usable key in keys with a user ID
+// for email.
+func checkSecretKey(keys []SecretKey, format, id, email string, now time.Time) error {
+	program := keyringProgram(format)
+	normalized, ok := normalizeKeyID(id)
+	if !ok {
+		return validationErrorf("invalid key ID %s: use the 16-digit long key ID or the full fingerprint; run 'gitid keys' to list them", id)
+	}
+	for _, key := range keys {
+		if !key.matches(normalized) {
+			continue
+		}
+		if key.Validity == "r" {
+			return validationErrorf("secret key %s is revoked; pick another key with 'gitid keys'", id)
+		}
+		if key.expired(now) {
+			return validationErrorf("secret key %s expired on %s; extend it with '%s --quick-set-expire %s <expire>' or pick another key", id, key.Expires.Format("2006-01-02"), program, key.Fingerprint)
+		}
+		if !key.hasEmail(email) {
+			return validationErrorf("secret key %s has no user ID for %s; add one with '%s --quick-add-uid %s \"Name <%s>\"'", id, email, program, key.Fingerprint, email)
+		}
+		return nil
+	}
+	return validationErrorf("secret key %s not found in your %s keyring; import it with '%s --import' or run 'gitid keys' to list available keys", id, program, program)
+}
+
+// validateKeyringKey checks id against the local keyring for format.
+func validateKeyringKey(format, id, email string) error {
+	keys, err := listSecretKeys(format)
+	if err != nil {
+		return err
+	}
+	return checkSecretKey(keys, format, id, email, time.Now())
+}
+
+// usableSecretKeys lists the unexpired keys for format that carry email,
+// or every unexpired key when email is empty.
+func usableSecretKeys(format, email string) ([]SecretKey, error) {
+	keys, err := listSecretKeys(format)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var usable []SecretKey
+	for _, key := range keys {
+		if key.Validity == "r" || key.expired(now) {
+			continue
+		}
+		if email != "" && !key.hasEmail(email) {
+			continue
+		}
+		usable = append(usable, key)
+	}
+	return usable, nil
+}
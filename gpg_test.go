@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+const secretKeyListing = `sec:u:255:22:612155993F197D83:1792204520:1792290920::u:::scSC:::+::ed25519:::0:
+fpr:::::::::2B1BEA720A8A3CA539DD9A11612155993F197D83:
+grp:::::::::BB137196CEB32881A7C5429E658B37E16AF67D9C:
+uid:u::::1792204520::77AA2002BBA04F2EA578092FB898D3FD747AE739::Test User <t@example.com>::::::::::0:
+ssb:u:255:18:9A3C2E0D1F6B7C44:1792204520::::::e:::+::cv25519::
+fpr:::::::::0E1D2C3B4A5968778695A4B39A3C2E0D1F6B7C44:
+sec:e:255:22:1111222233334444:1600000000:1600086400::u:::scSC:::+::ed25519:::0:
+fpr:::::::::AAAABBBBCCCCDDDDEEEEFFFF1111222233334444:
+uid:e::::1600000000::0000::Old Key <t@example.com>::::::::::0:
+`
+
+func TestParseSecretKeys(t *testing.T) {
+	keys := parseSecretKeys(secretKeyListing)
+	if len(keys) != 2 {
+		t.Fatalf("parseSecretKeys returned %d keys, want 2", len(keys))
+	}
+
+	key := keys[0]
+	if key.Fingerprint != "2B1BEA720A8A3CA539DD9A11612155993F197D83" {
+		t.Errorf("Fingerprint = %q", key.Fingerprint)
+	}
+	if len(key.Subkeys) != 1 || key.Subkeys[0] != "0E1D2C3B4A5968778695A4B39A3C2E0D1F6B7C44" {
+		t.Errorf("Subkeys = %q", key.Subkeys)
+	}
+	if len(key.UIDs) != 1 || key.UIDs[0] != "Test User <t@example.com>" {
+		t.Errorf("UIDs = %q", key.UIDs)
+	}
+	if !key.Expires.Equal(time.Unix(1792290920, 0)) {
+		t.Errorf("Expires = %v", key.Expires)
+	}
+}
+
+func TestCheckSecretKey(t *testing.T) {
+	keys := parseSecretKeys(secretKeyListing)
+	now := time.Unix(1792204600, 0)
+
+	tests := []struct {
+		name    string
+		id      string
+		email   string
+		wantErr string
+	}{
+		{"fingerprint", "2B1BEA720A8A3CA539DD9A11612155993F197D83", "t@example.com", ""},
+		{"long key id with 0x", "0x612155993F197D83", "t@example.com", ""},
+		{"subkey", "9A3C2E0D1F6B7C44!", "T@Example.com", ""},
+		{"wrong email", "612155993F197D83", "other@example.com", "no user ID for other@example.com"},
+		{"expired", "1111222233334444", "t@example.com", "expired on"},
+		{"missing", "DEADBEEFDEADBEEF", "t@example.com", "not found"},
+		{"short key id", "3F197D83", "t@example.com", "invalid key ID"},
+		{"fingerprint suffix", "A11612155993F197D83", "t@example.com", "invalid key ID"},
+		{"not hex", "612155993F197D8Z", "t@example.com", "invalid key ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSecretKey(keys, SigningOpenPGP, tt.id, tt.email, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkSecretKey(%q) failed: %v", tt.id, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkSecretKey(%q) error = %v, want containing %q", tt.id, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSwitchIdentityOpenPGPSigning(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	gnupgHome := t.TempDir()
+	originalGnupgHome, hadGnupgHome := os.LookupEnv("GNUPGHOME")
+	os.Setenv("GNUPGHOME", gnupgHome)
+	defer func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		if hadGnupgHome {
+			os.Setenv("GNUPGHOME", originalGnupgHome)
+		} else {
+			os.Unsetenv("GNUPGHOME")
+		}
+	}()
+
+	if err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Work User <work@example.com>", "ed25519", "sign", "1d").Run(); err != nil {
+		t.Skipf("could not generate test key: %v", err)
+	}
+	keys, err := usableSecretKeys(SigningOpenPGP, "work@example.com")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("usableSecretKeys = %v, %v; want one key", keys, err)
+	}
+
+	addIdentity("Work User", "work@example.com", "work")
+	addIdentity("Other User", "other@example.com", "other")
+	other, _ := findIdentityByIdentifier(t, "other")
+	if _, err := withSigningKey(other, SigningOpenPGP, keys[0].Fingerprint); err == nil {
+		t.Error("withSigningKey should reject a key without a matching user ID")
+	}
+	work, _ := findIdentityByIdentifier(t, "work")
+	work, err = withSigningKey(work, SigningOpenPGP, keys[0].Fingerprint)
+	if err != nil {
+		t.Fatalf("withSigningKey failed: %v", err)
+	}
+	if err := (gitConfigStore{}).Put(work); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
+	if got := gitConfigValue(t, "--global", "gpg.format"); got != SigningOpenPGP {
+		t.Errorf("gpg.format = %q, want %q", got, SigningOpenPGP)
+	}
+	if got := gitConfigValue(t, "--global", "user.signingkey"); got != keys[0].Fingerprint {
+		t.Errorf("user.signingkey = %q, want %q", got, keys[0].Fingerprint)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const hookMarker = "# Installed by gitid."
+
+// expectedIdentityKey is the repository config key that pins the identity
+// the pre-commit guard expects, by email.
+const expectedIdentityKey = "gitid.expect"
+
+// previousHooksPathKey remembers the global core.hooksPath that was set
+// before the global guard was installed.
+const previousHooksPathKey = "gitid.previoushookspath"
+
+// chainedHooks are the client-side hooks the global hooks directory
+// dispatches, so repositories keep running their own hooks.
+var chainedHooks = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch",
+	"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "pre-auto-gc",
+	"post-rewrite", "sendemail-validate", "post-index-change",
+}
+
+// dispatcherHook runs gitid's check for pre-commit and then hands over to
+// the hook of the same name that would have run without gitid: the one in
+// the previous global core.hooksPath, or else the repository's own.
+const dispatcherHook = `#!/bin/sh
+` + hookMarker + ` Remove with 'gitid hook uninstall --global'.
+hook=$(basename "$0")
+if [ "$hook" = pre-commit ]; then
+	if command -v gitid >/dev/null 2>&1; then
+		gitid hook check || exit 1
+	else
+		echo "gitid: not found in PATH, skipping identity check" >&2
+	fi
+fi
+previous=$(git config --global --path --get ` + previousHooksPathKey + `)
+if [ -n "$previous" ]; then
+	chained="$previous/$hook"
+else
+	chained="$(git rev-parse --git-common-dir)/hooks/$hook"
+fi
+if [ -x "$chained" ]; then
+	exec "$chained" "$@"
+fi
+exit 0
+`
+
+const preCommitHook = `#!/bin/sh
+` + hookMarker + ` Remove with 'gitid hook uninstall'.
+if ! command -v gitid >/dev/null 2>&1; then
+	echo "gitid: not found in PATH, skipping identity check" >&2
+	exit 0
+fi
+exec gitid hook check
+`
+
+func isGitidHook(path string) bool {
+	content, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(content), hookMarker)
+}
+
+func repoHookPath() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return "", fmt.Errorf("not inside a git repository")
+	}
+	return filepath.Join(strings.TrimSpace(string(out)), "hooks", "pre-commit"), nil
+}
+
+func globalHooksDir() (string, error) {
+	dir, err := gitidConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hooks"), nil
+}
+
+func writeHook(path, content string) error {
+	if _, err := os.Stat(path); err == nil && !isGitidHook(path) {
+		return fmt.Errorf("%s already exists and was not installed by gitid", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating hooks directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+		return fmt.Errorf("error writing hook: %w", err)
+	}
+	return nil
+}
+
+// installRepoHook installs the pre-commit guard into the current
+// repository's hooks directory.
+func installRepoHook() (string, error) {
+	path, err := repoHookPath()
+	if err != nil {
+		return "", err
+	}
+	return path, writeHook(path, preCommitHook)
+}
+
+func uninstallRepoHook() (bool, error) {
+	path, err := repoHookPath()
+	if err != nil {
+		return false, err
+	}
+	if !isGitidHook(path) {
+		return false, nil
+	}
+	if err := os.Remove(path); err != nil {
+		return false, fmt.Errorf("error removing hook: %w", err)
+	}
+	return true, nil
+}
+
+// installGlobalHook points the global core.hooksPath at gitid's hooks
+// directory so every repository runs the guard. A previous core.hooksPath
+// is remembered so the dispatchers can chain to it and uninstalling can put
+// it back.
+func installGlobalHook() (string, error) {
+	dir, err := globalHooksDir()
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range chainedHooks {
+		if err := writeHook(filepath.Join(dir, name), dispatcherHook); err != nil {
+			return "", err
+		}
+	}
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		current, isSet := f.get("core.hooksPath")
+		if isSet && current != dir {
+			if err := f.set(previousHooksPathKey, current); err != nil {
+				return fmt.Errorf("error saving core.hooksPath: %w", err)
+			}
+		}
+		if err := f.set("core.hooksPath", dir); err != nil {
+			return fmt.Errorf("error setting core.hooksPath: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// uninstallGlobalHook removes the hooks directory and restores the
+// core.hooksPath that was set before, or unsets it if there was none.
+func uninstallGlobalHook() (bool, error) {
+	dir, err := globalHooksDir()
+	if err != nil {
+		return false, err
+	}
+	installed := false
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		if current, _ := f.get("core.hooksPath"); current != dir {
+			return nil
+		}
+		installed = true
+		if previous, isSet := f.get(previousHooksPathKey); isSet {
+			if err := f.set("core.hooksPath", previous); err != nil {
+				return fmt.Errorf("error restoring core.hooksPath: %w", err)
+			}
+			if _, err := f.unset(previousHooksPathKey); err != nil {
+				return fmt.Errorf("error removing %s: %w", previousHooksPathKey, err)
+			}
+			return nil
+		}
+		if _, err := f.unset("core.hooksPath"); err != nil {
+			return fmt.Errorf("error removing core.hooksPath: %w", err)
+		}
+		return nil
+	})
+	if err != nil || !installed {
+		return false, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return false, fmt.Errorf("error removing hooks directory: %w", err)
+	}
+	return true, nil
+}
+
+// expectedIdentity returns the identity the current repository should
+// commit as and why: an identity pinned with expectIdentity, otherwise the
+// identity of the last binding that applies here.
+func expectedIdentity() (Identity, string, bool) {
+	if email := getConfigAt(ConfigTarget{Scope: ScopeLocal}, expectedIdentityKey); email != "" {
+		// An unreadable catalog only costs the display name: the pinned
+		// email is still enforced.
+		identities, err := getAllIdentities()
+		if err == nil {
+			for _, identity := range identities {
+				if identity.Email == email {
+					return identity, "expected for this repository", true
+				}
+			}
+		}
+		return Identity{Email: email}, "expected for this repository", true
+	}
+
+	bindings, err := listBindings()
+	if err != nil {
+		return Identity{}, "", false
+	}
+
+	var expected Identity
+	reason := ""
+	for _, binding := range bindings {
+		if !bindingApplies(binding) {
+			continue
+		}
+		if identity, found := bindingIdentity(binding); found {
+			expected = identity
+			reason = "bound by " + binding.condition()
+		}
+	}
+	return expected, reason, reason != ""
+}
+
+// expectIdentity pins identity as the one the guard expects in the current
+// repository. A zero identity removes the pin.
+func expectIdentity(identity Identity) error {
+	local := ConfigTarget{Scope: ScopeLocal}
+	if identity.Email == "" {
+		_, err := unsetConfigAt(local, expectedIdentityKey)
+		return err
+	}
+	if err := setConfigAt(local, expectedIdentityKey, identity.Email); err != nil {
+		return fmt.Errorf("error setting expected identity: %w", err)
+	}
+	return nil
+}
+
+// committingEmail returns the author email git will record, honouring
+// GIT_AUTHOR_EMAIL and every config scope.
+func committingEmail() (string, error) {
+	out, err := exec.Command("git", "var", "GIT_AUTHOR_IDENT").Output()
+	if err != nil {
+		return "", gitError(err, errNoIdentity)
+	}
+	ident := string(out)
+	start := strings.Index(ident, "<")
+	end := strings.LastIndex(ident, ">")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("unexpected author ident: %q", strings.TrimSpace(ident))
+	}
+	return ident[start+1 : end], nil
+}
+
+// checkCommitIdentity is the pre-commit guard. It fails with an
+// explanation when the author email does not match the expected identity.
+func checkCommitIdentity() error {
+	expected, reason, found := expectedIdentity()
+	if !found {
+		return nil
+	}
+
+	email, err := committingEmail()
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(email, expected.Email) {
+		return nil
+	}
+
+	display := expected.Email
+	identifier := expected.Email
+	if expected.Name != "" {
+		display = getIdentityDisplay(expected)
+	}
+	if expected.Nickname != "" {
+		identifier = expected.Nickname
+	}
+	return fmt.Errorf("commit blocked: you are committing as <%s>, but this repository expects %s (%s).\nRun: gitid switch --local %s", email, display, reason, shellQuote(identifier))
+}
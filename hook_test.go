@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallRepoHook(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	path, err := installRepoHook()
+	if err != nil {
+		t.Fatalf("installRepoHook failed: %v", err)
+	}
+	if !strings.HasSuffix(filepath.ToSlash(path), ".git/hooks/pre-commit") {
+		t.Errorf("hook path = %s, want the repository's .git/hooks/pre-commit", path)
+	}
+	if !isGitidHook(path) {
+		t.Error("installed hook should be recognised as gitid's")
+	}
+
+	removed, err := uninstallRepoHook()
+	if err != nil || !removed {
+		t.Fatalf("uninstallRepoHook = %v, %v; want removed", removed, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("hook file should be removed")
+	}
+
+	os.WriteFile(path, []byte("#!/bin/sh\nnpx lint-staged\n"), 0o755)
+	if _, err := installRepoHook(); err == nil {
+		t.Error("installRepoHook should refuse to overwrite a foreign hook")
+	}
+}
+
+func TestInstallGlobalHook(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	dir, err := installGlobalHook()
+	if err != nil {
+		t.Fatalf("installGlobalHook failed: %v", err)
+	}
+	if got := gitConfigValue(t, "--global", "core.hooksPath"); got != dir {
+		t.Errorf("core.hooksPath = %q, want %q", got, dir)
+	}
+
+	removed, err := uninstallGlobalHook()
+	if err != nil || !removed {
+		t.Fatalf("uninstallGlobalHook = %v, %v; want removed", removed, err)
+	}
+	if got := gitConfigValue(t, "--global", "core.hooksPath"); got != "" {
+		t.Errorf("core.hooksPath = %q after uninstall, want unset", got)
+	}
+}
+
+func TestCheckCommitIdentity(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	addIdentity("Work User", "work@example.com", "work")
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+
+	if err := checkCommitIdentity(); err != nil {
+		t.Errorf("checkCommitIdentity without expectation failed: %v", err)
+	}
+
+	work, _ := findIdentityByIdentifier(t, "work")
+	if err := expectIdentity(work); err != nil {
+		t.Fatalf("expectIdentity failed: %v", err)
+	}
+
+	err := checkCommitIdentity()
+	if err == nil {
+		t.Fatal("checkCommitIdentity should block a commit with the wrong identity")
+	}
+	for _, want := range []string{"me@example.com", "work (Work User <work@example.com>)", "gitid switch --local work"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("checkCommitIdentity error %q should mention %q", err, want)
+		}
+	}
+
+	os.Setenv("GIT_AUTHOR_EMAIL", "work@example.com")
+	defer os.Unsetenv("GIT_AUTHOR_EMAIL")
+	if err := checkCommitIdentity(); err != nil {
+		t.Errorf("checkCommitIdentity with matching GIT_AUTHOR_EMAIL failed: %v", err)
+	}
+}
+
+func TestCheckCommitIdentityBinding(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	addIdentity("OSS Handle", "oss@example.org", "oss")
+	oss, _ := findIdentityByIdentifier(t, "oss")
+	if _, err := bindIdentity(oss, BindBranch, "upstream/*"); err != nil {
+		t.Fatalf("bindIdentity failed: %v", err)
+	}
+	gitConfigAt(ConfigTarget{Scope: ScopeLocal}, "user.email", "me@corp.example").Run()
+	gitConfigAt(ConfigTarget{Scope: ScopeLocal}, "user.name", "Me").Run()
+
+	if err := checkCommitIdentity(); err != nil {
+		t.Errorf("checkCommitIdentity outside the bound branch failed: %v", err)
+	}
+
+	gitCheckout(t, "upstream/main")
+	err := checkCommitIdentity()
+	if err == nil || !strings.Contains(err.Error(), "onbranch:upstream/*") {
+		t.Errorf("checkCommitIdentity error = %v, want mismatch explained by the branch binding", err)
+	}
+}
+
+func TestGlobalHookRestoresPreviousHooksPath(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	exec.Command("git", "config", "--global", "core.hooksPath", "~/.githooks").Run()
+
+	if _, err := installGlobalHook(); err != nil {
+		t.Fatalf("installGlobalHook failed: %v", err)
+	}
+	if _, err := installGlobalHook(); err != nil {
+		t.Fatalf("reinstalling installGlobalHook failed: %v", err)
+	}
+	if got := gitConfigValue(t, "--global", previousHooksPathKey); got != "~/.githooks" {
+		t.Errorf("%s = %q, want ~/.githooks", previousHooksPathKey, got)
+	}
+
+	if _, err := uninstallGlobalHook(); err != nil {
+		t.Fatalf("uninstallGlobalHook failed: %v", err)
+	}
+	if got := gitConfigValue(t, "--global", "core.hooksPath"); got != "~/.githooks" {
+		t.Errorf("core.hooksPath after uninstall = %q, want ~/.githooks", got)
+	}
+	if got := gitConfigValue(t, "--global", previousHooksPathKey); got != "" {
+		t.Errorf("%s = %q after uninstall, want unset", previousHooksPathKey, got)
+	}
+}
+
+func TestGlobalHookChainsToRepositoryHook(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	repo := setupTestRepo(t)
+	switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, globalTarget)
+
+	marker := filepath.Join(repo, "post-commit-ran")
+	repoHook := filepath.Join(repo, ".git", "hooks", "post-commit")
+	os.WriteFile(repoHook, []byte("#!/bin/sh\ntouch "+shellQuote(marker)+"\n"), 0o755)
+
+	if _, err := installGlobalHook(); err != nil {
+		t.Fatalf("installGlobalHook failed: %v", err)
+	}
+	if out, err := exec.Command("git", "commit", "-q", "--allow-empty", "-m", "test").CombinedOutput(); err != nil {
+		t.Fatalf("git commit failed: %v\n%s", err, out)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("repository post-commit hook should run through the global dispatcher")
+	}
+}
@@ -1,175 +1,491 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
+	"strconv"
 	"strings"
 )
 
+// errIdentityNotFound is returned when an identifier matches no identity.
+var errIdentityNotFound = errors.New("identity not found")
+
+// identitySchemaKey records the version of the layout gitid stores
+// identities in. Version 1 keyed identity.<encoded email>.* sections;
+// version 2 keys them by a generated ID so identities never collide and
+// one email can have several identities.
+const identitySchemaKey = "gitid.schema"
+
+const identitySchema = 2
+
+// encodeEmail returns the section name schema 1 stored an identity under.
 func encodeEmail(email string) string {
 	return strings.ReplaceAll(strings.ReplaceAll(email, "@", "_at_"), ".", "_dot_")
 }
 
-func setNickname(email, nickname string) error {
-	section := encodeEmail(email)
-	nicknameCmd := fmt.Sprintf("identity.%s.nickname", section)
-	return exec.Command("git", "config", "--global", nicknameCmd, nickname).Run()
+func identityKey(id, attr string) string {
+	return fmt.Sprintf("identity.%s.%s", id, attr)
 }
 
-func getNickname(email string) string {
-	section := encodeEmail(email)
-	nicknameCmd := fmt.Sprintf("identity.%s.nickname", section)
-	out, err := exec.Command("git", "config", "--global", nicknameCmd).Output()
+// newIdentityID generates a random ID that no stored identity uses.
+func newIdentityID() (string, error) {
+	identities, err := getAllIdentities()
 	if err != nil {
-		return ""
+		return "", err
 	}
-	return strings.TrimSpace(string(out))
+	return unusedIdentityID(identities)
 }
 
-func hasNickname(email string) bool {
-	return getNickname(email) != ""
+// unusedIdentityID generates a random ID that none of identities uses.
+func unusedIdentityID(identities []Identity) (string, error) {
+	taken := make(map[string]bool)
+	for _, identity := range identities {
+		taken[identity.ID] = true
+	}
+	for {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("error generating identity ID: %w", err)
+		}
+		if id := hex.EncodeToString(b); !taken[id] {
+			return id, nil
+		}
+	}
 }
 
-func getIdentityDisplay(identity Identity) string {
-	if identity.Nickname != "" {
-		return fmt.Sprintf("%s (%s <%s>)", identity.Nickname, identity.Name, identity.Email)
+// isGeneratedID reports whether id was generated by newIdentityID rather
+// than derived from an email by schema 1.
+func isGeneratedID(id string) bool {
+	if len(id) != 12 {
+		return false
 	}
-	return fmt.Sprintf("%s <%s>", identity.Name, identity.Email)
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
 }
 
-func getAllIdentities() []Identity {
-	out, _ := exec.Command("git", "config", "--global", "--get-regexp", "^identity\\.").Output()
-	var identities []Identity
-	re := regexp.MustCompile(`identity\.(.+)\.name\s(.+)`)
-
-	for _, line := range strings.Split(string(out), "\n") {
-		matches := re.FindStringSubmatch(line)
-		if len(matches) > 2 {
-			section := matches[1]
-			name := matches[2]
-			emailCmd := fmt.Sprintf("identity.%s.email", section)
-			emailOut, _ := exec.Command("git", "config", "--global", emailCmd).Output()
-			email := strings.TrimSpace(string(emailOut))
-
-			identity := Identity{
-				Name:     name,
-				Email:    email,
-				Nickname: getNickname(email),
-			}
-			identities = append(identities, identity)
-		}
+// checkSchema refuses to write identities stored by a newer gitid, whose
+// layout this version may not preserve.
+func checkSchema() error {
+	return checkSchemaValue(getConfigAt(globalTarget, identitySchemaKey))
+}
+
+func checkSchemaValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", identitySchemaKey, value)
 	}
-	return identities
+	if version > identitySchema {
+		return fmt.Errorf("identities were stored by a newer gitid (schema %d, this version supports %d); upgrade gitid", version, identitySchema)
+	}
+	return nil
 }
 
-func findIdentityByIdentifier(identifier string) (Identity, bool) {
-	identities := getAllIdentities()
+// lookupIdentity returns the stored identity git's name and email belong
+// to: the one with both, or else the first one with the email.
+func lookupIdentity(name, email string) (Identity, bool, error) {
+	identities, err := getAllIdentities()
+	if err != nil {
+		return Identity{}, false, err
+	}
+	identity, found := lookupIdentityIn(identities, name, email)
+	return identity, found, nil
+}
 
+func lookupIdentityIn(identities []Identity, name, email string) (Identity, bool) {
+	var fallback Identity
+	found := false
 	for _, identity := range identities {
-		if identity.Nickname == identifier {
+		if identity.Email != email {
+			continue
+		}
+		if identity.Name == name {
 			return identity, true
 		}
+		if !found {
+			fallback, found = identity, true
+		}
 	}
+	return fallback, found
+}
 
-	for _, identity := range identities {
-		if identity.Email == identifier {
-			return identity, true
+// tagList returns the tags of identity, which are stored as one
+// comma-separated attribute.
+func (identity Identity) tagList() []string {
+	var tags []string
+	for _, tag := range strings.Split(identity.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
 		}
 	}
+	return tags
+}
 
-	for _, identity := range identities {
-		if identity.Name == identifier {
-			return identity, true
+func getIdentityDisplay(identity Identity) string {
+	if identity.Nickname != "" {
+		return fmt.Sprintf("%s (%s <%s>)", identity.Nickname, identity.Name, identity.Email)
+	}
+	return fmt.Sprintf("%s <%s>", identity.Name, identity.Email)
+}
+
+// getAllIdentities reads every identity.<id>.* section of the global
+// config in a single pass. Sections written by schema 1 are read the same
+// way, with the encoded email as their ID.
+func getAllIdentities() ([]Identity, error) {
+	entries, err := configRegexpAt(globalTarget, "^identity\\.")
+	if err != nil {
+		return nil, err
+	}
+	return parseIdentities(entries), nil
+}
+
+// lockedIdentities returns the identities of the global config like
+// getAllIdentities, reading the file identities are written to from f,
+// whose lock the caller holds.
+func lockedIdentities(f *configFile) ([]Identity, error) {
+	paths, writePath, err := configPaths(globalTarget)
+	if err != nil {
+		return nil, err
+	}
+	var entries []configEntry
+	for _, path := range paths {
+		if path == writePath {
+			entries = append(entries, f.entries()...)
+			continue
+		}
+		other, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
 		}
+		entries = append(entries, other.entries()...)
 	}
+	return parseIdentities(entries), nil
+}
 
-	for _, identity := range identities {
-		if strings.Contains(identity.Email, identifier) {
-			return identity, true
+// parseIdentities collects the identity.<id>.* entries into identities,
+// leaving out those without a name or an email.
+func parseIdentities(entries []configEntry) []Identity {
+	var identities []Identity
+	index := make(map[string]int)
+	for _, entry := range entries {
+		value := entry.Value
+		section, found := strings.CutPrefix(entry.Key, "identity.")
+		dot := strings.LastIndex(section, ".")
+		if !found || dot < 0 {
+			continue
+		}
+		id, attr := section[:dot], section[dot+1:]
+
+		i, seen := index[id]
+		if !seen {
+			i = len(identities)
+			index[id] = i
+			identities = append(identities, Identity{ID: id})
+		}
+		identity := &identities[i]
+		switch attr {
+		case "name":
+			identity.Name = value
+		case "email":
+			identity.Email = value
+		case "nickname":
+			identity.Nickname = value
+		case "signingformat":
+			identity.SigningFormat = value
+		case "signingkey":
+			identity.SigningKey = value
+		case "sshkey":
+			identity.SSHKey = value
+		case "tags":
+			identity.Tags = value
 		}
 	}
 
+	complete := identities[:0]
 	for _, identity := range identities {
-		if strings.Contains(identity.Name, identifier) {
-			return identity, true
+		if identity.Name != "" && identity.Email != "" {
+			complete = append(complete, identity)
 		}
 	}
-
-	return Identity{}, false
+	return complete
 }
 
-func addIdentity(name, email, nickname string) error {
-	section := encodeEmail(email)
-
-	nameCmd := fmt.Sprintf("identity.%s.name", section)
-	emailCmd := fmt.Sprintf("identity.%s.email", section)
-
-	if err := exec.Command("git", "config", "--global", nameCmd, name).Run(); err != nil {
-		return fmt.Errorf("error setting name: %w", err)
+// matchIdentities returns the identities identifier refers to, ranked by
+// rankIdentities. An exact match takes priority: a nickname, then an
+// email, then a name, then a tag, and only identities sharing the best
+// exact match are returned. Otherwise every identity in the best tier of
+// partial matches is. With exact set only exactMatches count.
+//
+// It reports whether the matches are good enough to act on without asking:
+// an exact, prefix or substring match is, while identifier only appearing
+// in order, as in "wk" for "Work User", is too loose.
+func matchIdentities(identities []Identity, identifier string, exact bool) ([]Identity, bool) {
+	if identifier == "" {
+		return nil, false
 	}
-	if err := exec.Command("git", "config", "--global", emailCmd, email).Run(); err != nil {
-		return fmt.Errorf("error setting email: %w", err)
+	if exact {
+		return exactMatches(identities, identifier), true
 	}
+	ranked := rankIdentities(identities, identifier)
+	if len(ranked) == 0 {
+		return nil, false
+	}
+	top := ranked[0].Score
 
-	if nickname != "" {
-		if err := setNickname(email, nickname); err != nil {
-			return fmt.Errorf("error setting nickname: %w", err)
+	var matches []Identity
+	for _, candidate := range ranked {
+		same := candidate.Score/tierSize == top/tierSize
+		if top >= tierExact*tierSize {
+			same = candidate.Score == top
+		}
+		if !same {
+			break
 		}
+		matches = append(matches, candidate.Identity)
 	}
+	return matches, top >= tierSubstring*tierSize
+}
 
+// validateIdentity checks the attributes every stored identity needs.
+// Without a name and an email an identity could not be switched to, and
+// it would not be read back from the config.
+func validateIdentity(identity Identity) error {
+	if strings.TrimSpace(identity.Name) == "" {
+		return validationErrorf("an identity needs a name")
+	}
+	if strings.TrimSpace(identity.Email) == "" {
+		return validationErrorf("an identity needs an email")
+	}
+	if strings.ContainsAny(identity.Name+identity.Email, "<>\n") {
+		return validationErrorf("name and email cannot contain '<', '>' or newlines")
+	}
+	if local, domain, found := strings.Cut(identity.Email, "@"); !found || local == "" || domain == "" || strings.ContainsAny(identity.Email, " \t") {
+		return validationErrorf("invalid email: %s", identity.Email)
+	}
 	return nil
 }
 
-func switchIdentity(name, email string) {
-	if err := exec.Command("git", "config", "--global", "user.name", name).Run(); err != nil {
-		fmt.Printf("Error setting user name: %v\n", err)
-		return
+// addIdentity stores a new identity in the global config.
+func addIdentity(name, email, nickname string) error {
+	return addToStore(gitConfigStore{}, Identity{Name: name, Email: email, Nickname: nickname})
+}
+
+// scopeTargets returns the scopes of the current directory an identity
+// can be active in: the global one and, inside a repository, the local one
+// and a separate worktree one.
+func scopeTargets() []ConfigTarget {
+	targets := []ConfigTarget{globalTarget}
+	if _, local, err := configPaths(ConfigTarget{Scope: ScopeLocal}); err == nil {
+		targets = append(targets, ConfigTarget{Scope: ScopeLocal})
+		if _, worktree, err := configPaths(ConfigTarget{Scope: ScopeWorktree}); err == nil && worktree != local {
+			targets = append(targets, ConfigTarget{Scope: ScopeWorktree})
+		}
 	}
-	if err := exec.Command("git", "config", "--global", "user.email", email).Run(); err != nil {
-		fmt.Printf("Error setting user email: %v\n", err)
-		return
+	return targets
+}
+
+// activeIn reports whether the user.name and user.email of f, the config
+// file of a scope, resolve to the identity with id.
+func activeIn(f *configFile, identities []Identity, id string) bool {
+	email, _ := f.get("user.email")
+	if email == "" {
+		return false
 	}
+	name, _ := f.get("user.name")
+	identity, found := lookupIdentityIn(identities, name, email)
+	return found && identity.ID == id
 }
 
-func switchIdentityByIdentifier(identifier string) error {
-	identity, found := findIdentityByIdentifier(identifier)
-	if !found {
-		return fmt.Errorf("identity not found: %s", identifier)
+// putIdentity writes every attribute of identity under its ID, generating
+// one for a new identity, and removes the attributes that are empty. Keys
+// gitid does not know about are left alone. Another identity with the
+// same name and email is an error, checked under the lock so concurrent
+// writers cannot both add it.
+//
+// When an existing identity changes, everything derived from it follows:
+// the include file of its bindings is regenerated, every scope of the
+// current directory it is active in is switched to it again, and a
+// repository pin on its old email is moved to the new one. All of it is
+// one transaction over the files involved, so a failure leaves every one
+// of them as it was.
+func putIdentity(identity Identity) (Identity, error) {
+	// Whether an existing identity is active in a scope, bound or pinned
+	// is only decided once the files are locked, so every file it could
+	// be written to takes part in the transaction. Those left unchanged
+	// are not written.
+	scopes := scopeTargets()
+	targets := []ConfigTarget{globalTarget}
+	include := ConfigTarget{Scope: ScopeFile}
+	if identity.ID != "" {
+		path, err := prepareIncludeFile(identity)
+		if err != nil {
+			return identity, err
+		}
+		include.File = path
+		targets = append(scopes, include)
 	}
 
-	switchIdentity(identity.Name, identity.Email)
-	return nil
+	attrs := [][2]string{
+		{"name", identity.Name},
+		{"email", identity.Email},
+		{"nickname", identity.Nickname},
+		{"signingformat", identity.SigningFormat},
+		{"signingkey", identity.SigningKey},
+		{"sshkey", identity.SSHKey},
+		{"tags", identity.Tags},
+	}
+	err := updateConfigs(targets, func(files []*configFile) error {
+		f := files[0]
+		schema, _ := f.get(identitySchemaKey)
+		if err := checkSchemaValue(schema); err != nil {
+			return err
+		}
+
+		identities, err := lockedIdentities(f)
+		if err != nil {
+			return err
+		}
+		var previous Identity
+		for _, other := range identities {
+			if other.ID == identity.ID {
+				previous = other
+			} else if other.Name == identity.Name && other.Email == identity.Email {
+				return validationErrorf("identity already exists: %s", getIdentityDisplay(other))
+			}
+		}
+		if identity.ID == "" {
+			if identity.ID, err = unusedIdentityID(identities); err != nil {
+				return err
+			}
+		}
+
+		var active []int
+		bound := false
+		if previous.ID != "" {
+			for i := range scopes {
+				if activeIn(files[i], identities, identity.ID) {
+					active = append(active, i)
+				}
+			}
+			bound = includes(f, include.File)
+		}
+		if identity.SigningKey != "" && (len(active) > 0 || bound) {
+			if err := validateSigningKey(identity.SigningFormat, identity.SigningKey, identity.Email); err != nil {
+				return err
+			}
+		}
+
+		for _, attr := range attrs {
+			key := identityKey(identity.ID, attr[0])
+			if attr[1] == "" {
+				if _, err := f.unset(key); err != nil {
+					return fmt.Errorf("error removing %s: %w", attr[0], err)
+				}
+				continue
+			}
+			if err := f.set(key, attr[1]); err != nil {
+				return fmt.Errorf("error setting %s: %w", attr[0], err)
+			}
+		}
+		if schema != strconv.Itoa(identitySchema) {
+			if err := f.set(identitySchemaKey, strconv.Itoa(identitySchema)); err != nil {
+				return err
+			}
+		}
+
+		for _, i := range active {
+			if err := applyIdentity(files[i], identity, scopes[i]); err != nil {
+				return fmt.Errorf("error re-applying identity in %s config: %w", scopes[i], err)
+			}
+		}
+		if bound {
+			if err := fillIncludeFile(files[len(scopes)], identity); err != nil {
+				return err
+			}
+		}
+		if previous.Email != "" && previous.Email != identity.Email && len(scopes) > 1 {
+			local := files[1]
+			if value, _ := local.get(expectedIdentityKey); value == previous.Email {
+				return local.set(expectedIdentityKey, identity.Email)
+			}
+		}
+		return nil
+	})
+	return identity, err
 }
 
-func updateIdentity(oldEmail, newName, newEmail, newNickname string) error {
-	if oldEmail != newEmail {
-		if err := deleteIdentity(oldEmail); err != nil {
-			return fmt.Errorf("error removing old identity: %w", err)
+// switchIdentity writes identity's user, signing and SSH settings to
+// target in a single transaction.
+func switchIdentity(identity Identity, target ConfigTarget) error {
+	if identity.SigningKey != "" {
+		if err := validateSigningKey(identity.SigningFormat, identity.SigningKey, identity.Email); err != nil {
+			return err
 		}
 	}
 
-	if err := addIdentity(newName, newEmail, newNickname); err != nil {
-		return fmt.Errorf("error adding updated identity: %w", err)
-	}
+	return updateConfig(target, func(f *configFile) error {
+		return applyIdentity(f, identity, target)
+	})
+}
 
-	return nil
+// applyIdentity sets identity's user, signing and SSH settings in f, the
+// config file of target, without validating them.
+func applyIdentity(f *configFile, identity Identity, target ConfigTarget) error {
+	if err := f.set("user.name", identity.Name); err != nil {
+		return fmt.Errorf("error setting user name in %s config: %w", target, err)
+	}
+	if err := f.set("user.email", identity.Email); err != nil {
+		return fmt.Errorf("error setting user email in %s config: %w", target, err)
+	}
+	if err := applySigning(f, identity, target); err != nil {
+		return err
+	}
+	return applySSHCommand(f, identity, target)
 }
 
-func deleteIdentity(email string) error {
-	section := encodeEmail(email)
+// updateIdentity changes the name, email and nickname of the identity
+// with id in place. Its other attributes and its bindings are kept, and
+// the scopes it is active in are updated with it; see putIdentity.
+func updateIdentity(id, newName, newEmail, newNickname string) error {
+	identity, found, err := gitConfigStore{}.Get(id)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", errIdentityNotFound, id)
+	}
 
-	nameCmd := fmt.Sprintf("identity.%s.name", section)
-	emailCmd := fmt.Sprintf("identity.%s.email", section)
-	nicknameCmd := fmt.Sprintf("identity.%s.nickname", section)
+	identity.Name, identity.Email, identity.Nickname = newName, newEmail, newNickname
+	return updateInStore(gitConfigStore{}, identity)
+}
 
-	if err := exec.Command("git", "config", "--global", "--unset", nameCmd).Run(); err != nil {
-		return fmt.Errorf("error removing name: %w", err)
+// deleteIdentity removes the identity with id and all of its attributes.
+func deleteIdentity(id string) error {
+	bindings, err := bindingsFor(id)
+	if err != nil {
+		return err
 	}
-	if err := exec.Command("git", "config", "--global", "--unset", emailCmd).Run(); err != nil {
-		return fmt.Errorf("error removing email: %w", err)
+	if len(bindings) > 0 {
+		var conditions []string
+		for _, binding := range bindings {
+			conditions = append(conditions, binding.condition())
+		}
+		return fmt.Errorf("identity is still bound to %s; run 'gitid unbind' first", strings.Join(conditions, ", "))
 	}
-	exec.Command("git", "config", "--global", "--unset", nicknameCmd).Run()
 
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		schema, _ := f.get(identitySchemaKey)
+		if err := checkSchemaValue(schema); err != nil {
+			return err
+		}
+		return f.removeSection("identity." + id)
+	})
+	if err != nil {
+		return fmt.Errorf("error removing identity: %w", err)
+	}
 	return nil
 }
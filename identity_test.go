@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEncodeEmail(t *testing.T) {
@@ -74,52 +78,81 @@ func TestGetIdentityDisplay(t *testing.T) {
 	}
 }
 
-func setupTestGitConfig(t *testing.T) func() {
+func setupTestGitConfig(t testing.TB) func() {
 	originalHome := os.Getenv("HOME")
+	originalConfigHome, hadConfigHome := os.LookupEnv("XDG_CONFIG_HOME")
+	originalCacheHome, hadCacheHome := os.LookupEnv("XDG_CACHE_HOME")
 	tempDir := t.TempDir()
 	os.Setenv("HOME", tempDir)
+	os.Setenv("XDG_CONFIG_HOME", filepath.Join(tempDir, ".config"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(tempDir, ".cache"))
 
 	exec.Command("git", "config", "--global", "init.defaultBranch", "main").Run()
 
 	return func() {
 		os.Setenv("HOME", originalHome)
+		if hadConfigHome {
+			os.Setenv("XDG_CONFIG_HOME", originalConfigHome)
+		} else {
+			os.Unsetenv("XDG_CONFIG_HOME")
+		}
+		if hadCacheHome {
+			os.Setenv("XDG_CACHE_HOME", originalCacheHome)
+		} else {
+			os.Unsetenv("XDG_CACHE_HOME")
+		}
 	}
 }
 
-func TestSetAndGetNickname(t *testing.T) {
+// findIdentityByIdentifier returns the identity identifier refers to. It
+// reports false when nothing matches, when several identities do and when
+// the only match is a loose one.
+func findIdentityByIdentifier(t testing.TB, identifier string) (Identity, bool) {
+	t.Helper()
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	candidates, sure := matchIdentities(identities, identifier, false)
+	if len(candidates) != 1 || !sure {
+		return Identity{}, false
+	}
+	return candidates[0], true
+}
+
+func TestSetNickname(t *testing.T) {
 	cleanup := setupTestGitConfig(t)
 	defer cleanup()
 
-	email := "test@example.com"
-	nickname := "testnick"
-
-	err := setNickname(email, nickname)
+	if err := addIdentity("Test User", "test@example.com", ""); err != nil {
+		t.Fatalf("addIdentity failed: %v", err)
+	}
+	store := gitConfigStore{}
+	identity, err := findIdentity(store, "test@example.com")
 	if err != nil {
-		t.Fatalf("setNickname failed: %v", err)
+		t.Fatalf("findIdentity failed: %v", err)
 	}
 
-	result := getNickname(email)
-	if result != nickname {
-		t.Errorf("getNickname() = %q, want %q", result, nickname)
+	identity.Nickname = "testnick"
+	if err := updateInStore(store, identity); err != nil {
+		t.Fatalf("updateInStore failed: %v", err)
 	}
 
-	if !hasNickname(email) {
-		t.Error("hasNickname() should return true after setting nickname")
+	stored, found, err := store.Get(identity.ID)
+	if err != nil || !found {
+		t.Fatalf("Get(%s) = %v, %v", identity.ID, found, err)
+	}
+	if stored.Nickname != "testnick" {
+		t.Errorf("nickname = %q, want testnick", stored.Nickname)
 	}
 }
 
-func TestGetNicknameNonExistent(t *testing.T) {
+func TestGetNonExistent(t *testing.T) {
 	cleanup := setupTestGitConfig(t)
 	defer cleanup()
 
-	email := "nonexistent@example.com"
-	result := getNickname(email)
-	if result != "" {
-		t.Errorf("getNickname() for non-existent email = %q, want empty string", result)
-	}
-
-	if hasNickname(email) {
-		t.Error("hasNickname() should return false for non-existent email")
+	if identity, found, err := (gitConfigStore{}).Get("0123456789ab"); err != nil || found {
+		t.Errorf("Get for a non-existent ID = %+v, %v, %v; want not found", identity, found, err)
 	}
 }
 
@@ -144,7 +177,11 @@ func TestAddIdentity(t *testing.T) {
 				t.Fatalf("addIdentity failed: %v", err)
 			}
 
-			section := encodeEmail(tt.email)
+			identity, _ := findIdentityByIdentifier(t, tt.email)
+			if !isGeneratedID(identity.ID) {
+				t.Errorf("identity stored under %q, want a generated ID", identity.ID)
+			}
+			section := identity.ID
 			nameCmd := fmt.Sprintf("identity.%s.name", section)
 			emailCmd := fmt.Sprintf("identity.%s.email", section)
 
@@ -164,11 +201,8 @@ func TestAddIdentity(t *testing.T) {
 				t.Errorf("Email not set correctly: got %q, want %q", strings.TrimSpace(string(emailOut)), tt.email)
 			}
 
-			if tt.nickname != "" {
-				nickname := getNickname(tt.email)
-				if nickname != tt.nickname {
-					t.Errorf("Nickname not set correctly: got %q, want %q", nickname, tt.nickname)
-				}
+			if identity.Nickname != tt.nickname {
+				t.Errorf("Nickname not set correctly: got %q, want %q", identity.Nickname, tt.nickname)
 			}
 		})
 	}
@@ -181,7 +215,9 @@ func TestSwitchIdentity(t *testing.T) {
 	name := "Test User"
 	email := "test@example.com"
 
-	switchIdentity(name, email)
+	if err := switchIdentity(Identity{Name: name, Email: email}, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
 
 	nameOut, err := exec.Command("git", "config", "--global", "user.name").Output()
 	if err != nil {
@@ -244,6 +280,11 @@ func TestFindIdentityByIdentifier(t *testing.T) {
 			shouldFind: true,
 			expected:   Identity{Name: "Jane Smith", Email: "jane@example.com", Nickname: ""},
 		},
+		{
+			name:       "ambiguous partial match",
+			identifier: "example.com",
+			shouldFind: false,
+		},
 		{
 			name:       "not found",
 			identifier: "nonexistent",
@@ -253,7 +294,7 @@ func TestFindIdentityByIdentifier(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			identity, found := findIdentityByIdentifier(tt.identifier)
+			identity, found := findIdentityByIdentifier(t, tt.identifier)
 			if found != tt.shouldFind {
 				t.Errorf("findIdentityByIdentifier(%q) found = %v, want %v", tt.identifier, found, tt.shouldFind)
 			}
@@ -266,15 +307,19 @@ func TestFindIdentityByIdentifier(t *testing.T) {
 	}
 }
 
-func TestSwitchIdentityByIdentifier(t *testing.T) {
+func TestSwitchFoundIdentity(t *testing.T) {
 	cleanup := setupTestGitConfig(t)
 	defer cleanup()
 
 	addIdentity("John Doe", "john@example.com", "johnny")
 
-	err := switchIdentityByIdentifier("johnny")
+	store := gitConfigStore{}
+	identity, err := findIdentity(store, "johnny")
 	if err != nil {
-		t.Fatalf("switchIdentityByIdentifier failed: %v", err)
+		t.Fatalf("findIdentity failed: %v", err)
+	}
+	if err := switchIdentity(identity, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
 	}
 
 	nameOut, _ := exec.Command("git", "config", "--global", "user.name").Output()
@@ -287,9 +332,8 @@ func TestSwitchIdentityByIdentifier(t *testing.T) {
 		t.Errorf("user.email not switched correctly")
 	}
 
-	err = switchIdentityByIdentifier("nonexistent")
-	if err == nil {
-		t.Error("switchIdentityByIdentifier should fail for non-existent identifier")
+	if _, err := findIdentity(store, "nonexistent"); !errors.Is(err, errIdentityNotFound) {
+		t.Errorf("findIdentity for a non-existent identifier returned %v, want not found", err)
 	}
 }
 
@@ -299,13 +343,14 @@ func TestDeleteIdentity(t *testing.T) {
 
 	email := "test@example.com"
 	addIdentity("Test User", email, "testnick")
+	identity, _ := findIdentityByIdentifier(t, email)
 
-	err := deleteIdentity(email)
+	err := deleteIdentity(identity.ID)
 	if err != nil {
 		t.Fatalf("deleteIdentity failed: %v", err)
 	}
 
-	section := encodeEmail(email)
+	section := identity.ID
 	nameCmd := fmt.Sprintf("identity.%s.name", section)
 	emailCmd := fmt.Sprintf("identity.%s.email", section)
 
@@ -319,8 +364,216 @@ func TestDeleteIdentity(t *testing.T) {
 		t.Error("Email config should be removed after deleteIdentity")
 	}
 
-	if hasNickname(email) {
-		t.Error("Nickname should be removed after deleteIdentity")
+	if _, found, _ := (gitConfigStore{}).Get(identity.ID); found {
+		t.Error("identity should be gone after deleteIdentity")
+	}
+}
+
+func TestUpdateIdentityKeepsActiveScopesInSync(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+	local := ConfigTarget{Scope: ScopeLocal}
+
+	addIdentity("Work User", "old@example.com", "work")
+	addIdentity("Personal", "me@example.com", "personal")
+	work, _ := findIdentityByIdentifier(t, "work")
+	personal, _ := findIdentityByIdentifier(t, "personal")
+	switchIdentity(personal, globalTarget)
+	switchIdentity(work, local)
+	expectIdentity(work)
+	exec.Command("git", "config", "--global", identityKey(work.ID, "team"), "platform").Run()
+
+	if err := updateIdentity(work.ID, "Work Person", "new@example.com", "job"); err != nil {
+		t.Fatalf("updateIdentity failed: %v", err)
+	}
+
+	if got := getConfigAt(local, "user.email"); got != "new@example.com" {
+		t.Errorf("local user.email = %q, want the edited email", got)
+	}
+	if got := getConfigAt(local, "user.name"); got != "Work Person" {
+		t.Errorf("local user.name = %q, want the edited name", got)
+	}
+	if got := getConfigAt(globalTarget, "user.email"); got != "me@example.com" {
+		t.Errorf("global user.email = %q, the scope of another identity should be left alone", got)
+	}
+	if got := getConfigAt(local, expectedIdentityKey); got != "new@example.com" {
+		t.Errorf("repository pin = %q, want it moved to the edited email", got)
+	}
+	if got := getConfigAt(globalTarget, identityKey(work.ID, "team")); got != "platform" {
+		t.Errorf("unknown attribute = %q, want it kept by the edit", got)
+	}
+
+	key := filepath.Join(t.TempDir(), "id_work")
+	os.WriteFile(key, []byte("key"), 0o600)
+	work, _, _ = gitConfigStore{}.Get(work.ID)
+	work, err := withSSHKey(work, key)
+	if err != nil {
+		t.Fatalf("withSSHKey failed: %v", err)
+	}
+	if err := updateInStore(gitConfigStore{}, work); err != nil {
+		t.Fatalf("updateInStore failed: %v", err)
+	}
+	if got := getConfigAt(local, "core.sshCommand"); got != sshCommandFor(key) {
+		t.Errorf("local core.sshCommand = %q, want the new key of the active identity", got)
+	}
+
+	if err := updateIdentity(work.ID, "Personal", "me@example.com", "job"); err == nil {
+		t.Error("updateIdentity should refuse to duplicate another identity")
+	}
+}
+
+func TestPutIdentityIsAtomic(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	defer func(timeout time.Duration) { configLockTimeout = timeout }(configLockTimeout)
+	configLockTimeout = 50 * time.Millisecond
+
+	addIdentity("Existing User", "existing@example.com", "existing")
+	path := filepath.Join(os.Getenv("HOME"), ".gitconfig")
+	before, _ := os.ReadFile(path)
+
+	os.WriteFile(path+".lock", nil, 0o644)
+	err := addIdentity("Locked Out", "locked@example.com", "locked")
+	os.Remove(path + ".lock")
+	if !errors.Is(err, errConfigLocked) {
+		t.Fatalf("addIdentity while the config is locked returned %v, want errConfigLocked", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("a failed add changed the config:\n%s", after)
+	}
+
+	// Editing an identity that is active in the repository locks the
+	// repository config too; while it is held nothing is written.
+	repo := setupTestRepo(t)
+	existing, _ := findIdentityByIdentifier(t, "existing")
+	switchIdentity(existing, ConfigTarget{Scope: ScopeLocal})
+	local := filepath.Join(repo, ".git", "config")
+	before, _ = os.ReadFile(path)
+	localBefore, _ := os.ReadFile(local)
+	os.WriteFile(local+".lock", nil, 0o644)
+	err = updateIdentity(existing.ID, "Renamed User", "renamed@example.com", "existing")
+	os.Remove(local + ".lock")
+	if !errors.Is(err, errConfigLocked) {
+		t.Fatalf("updateIdentity with the repository config locked returned %v, want errConfigLocked", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("a failed edit changed the global config:\n%s", after)
+	}
+	if after, _ := os.ReadFile(local); string(after) != string(localBefore) {
+		t.Errorf("a failed edit changed the repository config:\n%s", after)
+	}
+
+	// A newer schema is checked under the lock and aborts the whole write.
+	exec.Command("git", "config", "--global", identitySchemaKey, "99").Run()
+	before, _ = os.ReadFile(path)
+	if err := addIdentity("Too Old", "old@example.com", "old"); err == nil {
+		t.Fatal("addIdentity should refuse a catalog with a newer schema")
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Errorf("a refused add changed the config:\n%s", after)
+	}
+}
+
+func TestConcurrentAddIdentity(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- addIdentity(fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("user%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent addIdentity failed: %v", err)
+		}
+	}
+
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	if got := len(identities); got != writers {
+		t.Errorf("%d identities stored after %d concurrent adds", got, writers)
+	}
+
+	// Adding the same identity concurrently must store it only once.
+	errs = make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- addIdentity("Same User", "same@example.com", "")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	added := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			added++
+		case exitStatus(err) != ExitInvalid:
+			t.Errorf("duplicate addIdentity failed with %v, want a validation error", err)
+		}
+	}
+	if added != 1 {
+		t.Errorf("%d of %d identical concurrent adds succeeded, want exactly one", added, writers)
+	}
+	if identities, _ := getAllIdentities(); len(identities) != writers+1 {
+		t.Errorf("%d identities stored after the identical adds, want %d", len(identities), writers+1)
+	}
+}
+
+// TestConcurrentAddIdentityProcesses runs the adds in separate gitid
+// processes, which only the lock file keeps apart. The test binary
+// re-runs itself as the writer when GITID_TEST_ADD is set.
+func TestConcurrentAddIdentityProcesses(t *testing.T) {
+	if n := os.Getenv("GITID_TEST_ADD"); n != "" {
+		if err := addIdentity("Process "+n, "process"+n+"@example.com", "process"+n); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	const writers = 8
+	var cmds []*exec.Cmd
+	for i := 0; i < writers; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run", "^TestConcurrentAddIdentityProcesses$")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("GITID_TEST_ADD=%d", i))
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+	for _, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("writer process failed: %v", err)
+		}
+	}
+
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	if len(identities) != writers {
+		t.Fatalf("%d identities stored after %d concurrent processes", len(identities), writers)
+	}
+	for _, identity := range identities {
+		if identity.Name == "" || identity.Email == "" || identity.Nickname == "" {
+			t.Errorf("half-written identity %+v", identity)
+		}
 	}
 }
 
@@ -332,7 +585,10 @@ func TestGetAllIdentities(t *testing.T) {
 	addIdentity("Jane Smith", "jane@example.com", "")
 	addIdentity("Bob Wilson", "bob@company.org", "bobby")
 
-	identities := getAllIdentities()
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
 
 	if len(identities) != 3 {
 		t.Errorf("getAllIdentities() returned %d identities, want 3", len(identities))
@@ -366,3 +622,122 @@ func TestGetAllIdentities(t *testing.T) {
 		}
 	}
 }
+
+// writeTestCatalog appends n identities to the global config directly,
+// which is much faster than adding them one by one through git.
+func writeTestCatalog(tb testing.TB, n int) {
+	tb.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "[identity \"%012x\"]\n\tname = User %d\n\temail = user%d@example.com\n\tnickname = user%d\n", i, i, i, i)
+	}
+
+	path := filepath.Join(os.Getenv("HOME"), ".gitconfig")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// countGitCalls puts a wrapper in front of git on PATH and returns a
+// function reporting how many times git was run since.
+func countGitCalls(t *testing.T) func() int {
+	t.Helper()
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls")
+	script := fmt.Sprintf("#!/bin/sh\necho >> %q\nexec %q \"$@\"\n", log, git)
+	if err := os.WriteFile(filepath.Join(dir, "git"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return func() int {
+		data, _ := os.ReadFile(log)
+		os.Remove(log)
+		return strings.Count(string(data), "\n")
+	}
+}
+
+func TestGetAllIdentitiesSpecialValues(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	if err := addIdentity("Doe,  Jane ", "jane@example.com", ""); err != nil {
+		t.Fatalf("addIdentity failed: %v", err)
+	}
+	identity, _ := findIdentityByIdentifier(t, "jane@example.com")
+	if err := exec.Command("git", "config", "--global", identityKey(identity.ID, "nickname"), "two\nlines").Run(); err != nil {
+		t.Fatalf("setting a multi-line nickname failed: %v", err)
+	}
+
+	identity, _ = findIdentityByIdentifier(t, "jane@example.com")
+	if identity.Name != "Doe,  Jane " || identity.Nickname != "two\nlines" {
+		t.Errorf("getAllIdentities read name %q and nickname %q", identity.Name, identity.Nickname)
+	}
+}
+
+func TestCatalogLoadsInOneGitCall(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	writeTestCatalog(t, 50)
+	t.Setenv(configBackendEnv, "git")
+	calls := countGitCalls(t)
+
+	loaders := []struct {
+		name string
+		load func() int
+	}{
+		{"getAllIdentities", func() int { identities, _ := getAllIdentities(); return len(identities) }},
+		{"predictIdentities", func() int { return len(predictIdentities("")) / 3 }},
+		{"initialModel", func() int { return len(initialModel(gitConfigStore{}).identities) }},
+	}
+	for _, tt := range loaders {
+		t.Run(tt.name, func(t *testing.T) {
+			calls()
+			if n := tt.load(); n != 50 {
+				t.Errorf("loaded %d identities, want 50", n)
+			}
+			if n := calls(); n != 1 {
+				t.Errorf("ran git %d times, want 1", n)
+			}
+		})
+	}
+}
+
+var catalogSizes = []int{10, 100, 500}
+
+func benchmarkCatalog(b *testing.B, load func()) {
+	for _, n := range catalogSizes {
+		b.Run(fmt.Sprintf("identities=%d", n), func(b *testing.B) {
+			cleanup := setupTestGitConfig(b)
+			defer cleanup()
+			writeTestCatalog(b, n)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				load()
+			}
+		})
+	}
+}
+
+func BenchmarkGetAllIdentities(b *testing.B) {
+	benchmarkCatalog(b, func() { getAllIdentities() })
+}
+
+func BenchmarkPredictIdentities(b *testing.B) {
+	benchmarkCatalog(b, func() { predictIdentities("") })
+}
+
+func BenchmarkInitialModel(b *testing.B) {
+	benchmarkCatalog(b, func() { initialModel(gitConfigStore{}) })
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 )
@@ -8,13 +9,22 @@ import (
 func main() {
 	setupCompletion()
 
+	store := gitConfigStore{}
 	if len(os.Args) == 1 {
-		runTUI()
+		runTUI(store)
 		return
 	}
 
-	if err := handleCLICommand(os.Args[1:]); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	if err := handleCLICommand(store, os.Args[1:]); err != nil {
+		var childErr *childExitError
+		var jsonErr *jsonError
+		switch {
+		case errors.As(err, &childErr):
+		case errors.As(err, &jsonErr):
+			writeJSONError(os.Stderr, jsonErr.err)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(exitStatus(err))
 	}
 }
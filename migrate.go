@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// legacyIdentities returns the identities still stored under a section
+// derived from their email, as schema 1 did.
+func legacyIdentities() ([]Identity, error) {
+	identities, err := getAllIdentities()
+	if err != nil {
+		return nil, err
+	}
+	var legacy []Identity
+	for _, identity := range identities {
+		if !isGeneratedID(identity.ID) {
+			legacy = append(legacy, identity)
+		}
+	}
+	return legacy, nil
+}
+
+// sectionEntries returns every key and value of the global identity.<id>
+// section, including keys gitid does not know about.
+func sectionEntries(id string) ([]configEntry, error) {
+	pattern := "^identity\\." + regexp.QuoteMeta(id) + "\\.[^.]+$"
+	entries, err := configRegexpAt(globalTarget, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity.%s: %w", id, err)
+	}
+	return entries, nil
+}
+
+// migrateIdentity moves a schema 1 identity to a section under a new ID.
+// Every key of the old section is copied as is, so attributes written by
+// later gitid versions survive. The copy and the removal of the old
+// section are one transaction; the bindings are repointed at an include
+// file named after the new ID afterwards.
+func migrateIdentity(identity Identity) (Identity, error) {
+	entries, err := sectionEntries(identity.ID)
+	if err != nil {
+		return identity, err
+	}
+	id, err := newIdentityID()
+	if err != nil {
+		return identity, err
+	}
+
+	oldID := identity.ID
+	identity.ID = id
+	err = updateConfig(globalTarget, func(f *configFile) error {
+		for _, entry := range entries {
+			attr := entry.Key[strings.LastIndex(entry.Key, ".")+1:]
+			if err := f.add(identityKey(id, attr), entry.Value); err != nil {
+				return fmt.Errorf("error copying %s: %w", entry.Key, err)
+			}
+		}
+		if err := f.removeSection("identity." + oldID); err != nil {
+			return fmt.Errorf("error removing identity.%s: %w", oldID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return identity, err
+	}
+	if err := moveBindings(oldID, identity); err != nil {
+		return identity, &partialWriteError{done: "identity moved to " + id, err: err}
+	}
+	return identity, nil
+}
+
+// migrateIdentities converts every schema 1 identity and records the
+// current schema version. It returns the migrated identities.
+func migrateIdentities() ([]Identity, error) {
+	if err := checkSchema(); err != nil {
+		return nil, err
+	}
+
+	legacy, err := legacyIdentities()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrated []Identity
+	for _, identity := range legacy {
+		identity, err := migrateIdentity(identity)
+		if err != nil {
+			err = fmt.Errorf("error migrating %s: %w", getIdentityDisplay(identity), err)
+			if len(migrated) > 0 {
+				err = &partialWriteError{done: fmt.Sprintf("%d identities migrated", len(migrated)), err: err}
+			}
+			return migrated, err
+		}
+		migrated = append(migrated, identity)
+	}
+
+	if err := setConfigAt(globalTarget, identitySchemaKey, strconv.Itoa(identitySchema)); err != nil {
+		return migrated, fmt.Errorf("error setting %s: %w", identitySchemaKey, err)
+	}
+	return migrated, nil
+}
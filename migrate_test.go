@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIdentitiesWithCollidingEmails(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	emails := []string{"a.b@x.com", "a_dot_b@x.com"}
+	for _, email := range emails {
+		if err := addIdentity("A B", email, ""); err != nil {
+			t.Fatalf("addIdentity(%s) failed: %v", email, err)
+		}
+	}
+	if err := addIdentity("A B (bot)", "a.b@x.com", "bot"); err != nil {
+		t.Fatalf("addIdentity with a shared email failed: %v", err)
+	}
+	if err := addIdentity("A B", "a.b@x.com", ""); err == nil {
+		t.Error("addIdentity should refuse an identity that already exists")
+	}
+
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	if len(identities) != 3 {
+		t.Fatalf("getAllIdentities() = %+v, want 3 identities", identities)
+	}
+	if bot, found := findIdentityByIdentifier(t, "bot"); !found || bot.Name != "A B (bot)" {
+		t.Errorf("findIdentityByIdentifier(bot) = %+v, %v", bot, found)
+	}
+	if schema := getConfigAt(globalTarget, identitySchemaKey); schema != "2" {
+		t.Errorf("%s = %q, want 2", identitySchemaKey, schema)
+	}
+}
+
+func TestMigrateIdentities(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	workDir := setupBoundRepo(t)
+
+	// Lay out an identity and a binding the way schema 1 stored them.
+	legacy := "identity." + encodeEmail("work@example.com") + "."
+	for _, kv := range [][2]string{
+		{"name", "Work User"},
+		{"email", "work@example.com"},
+		{"nickname", "work"},
+		{"sshkey", "/keys/id_work"},
+		{"futureattr", "kept"},
+	} {
+		if err := exec.Command("git", "config", "--global", legacy+kv[0], kv[1]).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath, _ := includeFilePath(encodeEmail("work@example.com"))
+	os.MkdirAll(filepath.Dir(oldPath), 0o755)
+	os.WriteFile(oldPath, []byte("[user]\n\tname = Work User\n\temail = work@example.com\n"), 0o644)
+	pattern, _ := normalizeGitdirPattern(workDir)
+	exec.Command("git", "config", "--global", "includeIf.gitdir:"+pattern+".path", oldPath).Run()
+
+	before, found := findIdentityByIdentifier(t, "work")
+	if !found || isGeneratedID(before.ID) {
+		t.Fatalf("fallback reader should find the legacy identity, got %+v, %v", before, found)
+	}
+
+	migrated, err := migrateIdentities()
+	if err != nil {
+		t.Fatalf("migrateIdentities failed: %v", err)
+	}
+	if len(migrated) != 1 || !isGeneratedID(migrated[0].ID) {
+		t.Fatalf("migrateIdentities = %+v, want one identity with a generated ID", migrated)
+	}
+
+	after, _ := findIdentityByIdentifier(t, "work")
+	before.ID = after.ID
+	if !reflect.DeepEqual(after, before) {
+		t.Errorf("migrated identity = %+v, want %+v", after, before)
+	}
+	if value := getConfigAt(globalTarget, identityKey(after.ID, "futureattr")); value != "kept" {
+		t.Errorf("unknown attribute after migration = %q, want kept", value)
+	}
+	if value := getConfigAt(globalTarget, legacy+"name"); value != "" {
+		t.Errorf("legacy section should be removed, name = %q", value)
+	}
+	if schema := getConfigAt(globalTarget, identitySchemaKey); schema != "2" {
+		t.Errorf("%s = %q, want 2", identitySchemaKey, schema)
+	}
+
+	bindings, _ := bindingsFor(after.ID)
+	if len(bindings) != 1 || bindings[0].Pattern != pattern {
+		t.Errorf("bindingsFor(%s) = %+v, want the migrated binding", after.ID, bindings)
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Error("legacy include file should be removed")
+	}
+	if current, _ := getEffectiveIdentity(); current.ID != after.ID {
+		t.Errorf("effective identity in the bound repository = %+v, want %s", current, after.ID)
+	}
+
+	if again, err := migrateIdentities(); err != nil || len(again) != 0 {
+		t.Errorf("second migration = %+v, %v, want nothing to do", again, err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	exec.Command("git", "config", "--global", identitySchemaKey, "99").Run()
+	if err := addIdentity("Work User", "work@example.com", ""); err == nil {
+		t.Error("addIdentity should refuse to write identities stored by a newer schema")
+	}
+}
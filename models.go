@@ -1,16 +1,279 @@
 package main
 
-import "github.com/charmbracelet/bubbles/textinput"
+import (
+	"text/template"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+)
 
 type Identity struct {
-	Name     string
-	Email    string
-	Nickname string
+	ID            string
+	Name          string
+	Email         string
+	Nickname      string
+	SigningFormat string
+	SigningKey    string
+	SSHKey        string
+	// Tags are free-form labels identities can be searched by, stored
+	// comma-separated; see tagList.
+	Tags string
+}
+
+// ConfigScope names the git config level an identity is written to.
+type ConfigScope string
+
+const (
+	ScopeGlobal   ConfigScope = "global"
+	ScopeLocal    ConfigScope = "local"
+	ScopeWorktree ConfigScope = "worktree"
+	ScopeFile     ConfigScope = "file"
+)
+
+// ConfigTarget is a config scope plus, for ScopeFile, the file path.
+type ConfigTarget struct {
+	Scope ConfigScope
+	File  string
+}
+
+// EffectiveIdentity is the user.name/user.email git resolves for the
+// current directory, along with where the email was read from and, when it
+// came from a gitid include file, the binding that selected it.
+type EffectiveIdentity struct {
+	Identity
+	Scope   string
+	Origin  string
+	Binding Binding
+}
+
+// BindingKind is the includeIf condition type a binding is keyed on.
+type BindingKind string
+
+const (
+	BindGitdir BindingKind = "gitdir"
+	BindRemote BindingKind = "hasconfig:remote.*.url"
+	BindBranch BindingKind = "onbranch"
+)
+
+// bindingKinds lists every kind gitid manages, most specific prefix first.
+var bindingKinds = []BindingKind{BindRemote, BindGitdir, BindBranch}
+
+// Binding ties an includeIf condition in the global config to the include
+// file gitid generates for an identity.
+type Binding struct {
+	Kind    BindingKind
+	Pattern string
+	Path    string
+}
+
+// SecretKey is a secret key from the local GnuPG or gpgsm keyring.
+type SecretKey struct {
+	Fingerprint string
+	KeyID       string
+	Subkeys     []string
+	UIDs        []string
+	Validity    string
+	Expires     time.Time
+}
+
+// IdentityJSON is an identity as printed by --json output. ID is empty
+// for an active identity that is not stored. Scope, Origin and Binding are
+// only set for the identity that is active.
+type IdentityJSON struct {
+	ID            string   `json:"id,omitempty"`
+	Name          string   `json:"name"`
+	Email         string   `json:"email"`
+	Nickname      string   `json:"nickname"`
+	SigningFormat string   `json:"signing_format,omitempty"`
+	SigningKey    string   `json:"signing_key,omitempty"`
+	SSHKey        string   `json:"ssh_key,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	Active        bool     `json:"active"`
+	Scope         string   `json:"scope,omitempty"`
+	Origin        string   `json:"origin,omitempty"`
+	Binding       string   `json:"binding,omitempty"`
+}
+
+// IdentityListJSON is the --json output of gitid list.
+type IdentityListJSON struct {
+	Version    int            `json:"version"`
+	Identities []IdentityJSON `json:"identities"`
+}
+
+// IdentityResultJSON is the --json output of gitid current and gitid show.
+type IdentityResultJSON struct {
+	Version  int          `json:"version"`
+	Identity IdentityJSON `json:"identity"`
+}
+
+// OutputOptions selects how list, current and show print identities: as
+// JSON, through a --format template, or as text when neither is set.
+type OutputOptions struct {
+	JSON     bool
+	Template *template.Template
+}
+
+// ErrorJSON is written to stderr instead of a plain message when a
+// command fails under --json.
+type ErrorJSON struct {
+	Version int `json:"version"`
+	Error   struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ImportStrategy decides what gitid import does with an identity whose
+// email or nickname is already taken.
+type ImportStrategy string
+
+const (
+	ImportSkip      ImportStrategy = "skip"
+	ImportOverwrite ImportStrategy = "overwrite"
+	ImportRename    ImportStrategy = "rename"
+)
+
+// ImportAction is one step of an import plan.
+type ImportAction struct {
+	Kind     string
+	Identity Identity
+	Reason   string
+	// TakeNickname is the ID of an existing identity whose nickname the
+	// imported identity takes over.
+	TakeNickname string
+}
+
+// promptCacheEntry is the effective identity of one repository together
+// with the state of every file that could change it. Stored is when it was
+// resolved, in Unix nanoseconds; the oldest entries are evicted first.
+type promptCacheEntry struct {
+	Identity EffectiveIdentity `json:"identity"`
+	Stamps   []fileStamp       `json:"stamps"`
+	Stored   int64             `json:"stored"`
 }
 
+// fileStamp records the size and modification time of a file, or zeros
+// when it does not exist.
+type fileStamp struct {
+	Path    string `json:"path"`
+	ModTime int64  `json:"mtime"`
+	Size    int64  `json:"size"`
+}
+
+// IdentityStore keeps the identity catalog and applies identities. The
+// git config store is the real one; the in-memory store backs tests and
+// --dry-run.
+type IdentityStore interface {
+	// List returns every identity in the catalog.
+	List() ([]Identity, error)
+	// Get returns the identity with id.
+	Get(id string) (Identity, bool, error)
+	// Put replaces all attributes of the identity with identity's ID, or
+	// adds identity under a new ID when it has none.
+	Put(identity Identity) error
+	// Delete removes the identity with id.
+	Delete(id string) error
+	// SetActive makes identity the one git uses in target.
+	SetActive(identity Identity, target ConfigTarget) error
+}
+
+// gitConfigStore keeps identities as identity.* keys in the global git
+// config.
+type gitConfigStore struct{}
+
+// memoryStore keeps identities in memory and records which identity was
+// activated in each target.
+type memoryStore struct {
+	identities []Identity
+	active     map[ConfigTarget]Identity
+	lastID     int
+}
+
+// configEntry is one variable of a git config file. Key is canonical:
+// section and variable name in lower case, subsection as written. Implicit
+// is set for a variable without "= value", which git reads as true.
+type configEntry struct {
+	Key      string
+	Value    string
+	Implicit bool
+	Origin   string
+}
+
+// configFile is a parsed git config file. Sections and variables point
+// into content, which is kept as read so that edits touch nothing but the
+// variables they change.
+type configFile struct {
+	path     string
+	content  string
+	sections []configSection
+	vars     []configVar
+}
+
+// configSection is a section header. start is the offset of its line and
+// end the offset just past its "]".
+type configSection struct {
+	name          string
+	subsection    string
+	hasSubsection bool
+	start, end    int
+}
+
+// configVar is a variable of section. It spans content[start:end], from
+// the start of its line, or of its name when it shares the line with the
+// header, through its line ending.
+type configVar struct {
+	section    int
+	name       string
+	value      string
+	implicit   bool
+	start, end int
+}
+
+// includeContext is what includeIf conditions are evaluated against.
+type includeContext struct {
+	gitDir     string
+	branch     string
+	remoteURLs []string
+}
+
+// configBackend reads and writes git config. The git backend runs the git
+// binary; the native one parses the files itself so gitid works where git
+// is not installed.
+type configBackend interface {
+	// Get returns the value of key in target.
+	Get(target ConfigTarget, key string) (string, bool, error)
+	// GetRegexp returns the variables in target whose key matches pattern.
+	GetRegexp(target ConfigTarget, pattern string) ([]configEntry, error)
+	// Set replaces the value of key in target.
+	Set(target ConfigTarget, key, value string) error
+	// Add adds a value to key in target, keeping the existing ones.
+	Add(target ConfigTarget, key, value string) error
+	// UnsetAll removes every value of key from target and reports whether
+	// there was any.
+	UnsetAll(target ConfigTarget, key string) (bool, error)
+	// RemoveSection removes a section such as identity.<id> from target.
+	RemoveSection(target ConfigTarget, section string) error
+	// Effective returns the value of key git uses in the current
+	// directory along with the scope and file it comes from.
+	Effective(key string) (configEntry, string, bool, error)
+}
+
+type gitBackend struct{}
+
+// exactStore wraps a store so that identifiers only match a nickname,
+// email or name exactly. It is what --exact selects.
+type exactStore struct {
+	IdentityStore
+}
+
+type nativeBackend struct{}
+
 type Model struct {
+	store            IdentityStore
 	identities       []Identity
 	cursor           int
+	target           ConfigTarget
+	errMsg           string
 	showConfirmation bool
 	confirmChoices   []string
 	confirmCursor    int
@@ -29,3 +292,29 @@ type CompletionPromptModel struct {
 	shouldInstall bool
 	finished      bool
 }
+
+// rankedIdentity is an identity with its score against a search query.
+type rankedIdentity struct {
+	Identity
+	Score int
+}
+
+// IdentityPickerModel lets the user narrow identities down by typing a
+// query and pick one of the best matches.
+type IdentityPickerModel struct {
+	title      string
+	filter     textinput.Model
+	identities []Identity
+	ranked     []rankedIdentity
+	cursor     int
+	chosen     bool
+	cancelled  bool
+}
+
+type PickerModel struct {
+	title     string
+	choices   []string
+	cursor    int
+	chosen    bool
+	cancelled bool
+}
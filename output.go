@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// jsonSchemaVersion is the "version" field of every --json document. It
+// is bumped whenever a field is removed or changes meaning; new fields may
+// be added without a bump.
+const jsonSchemaVersion = 1
+
+// Error codes reported in the "code" field of --json errors.
+const (
+	ErrCodeNotFound     = "not_found"
+	ErrCodeAmbiguous    = "ambiguous"
+	ErrCodeNoIdentity   = "no_identity"
+	ErrCodeGitMissing   = "git_missing"
+	ErrCodeConfigLocked = "config_locked"
+	ErrCodeInvalid      = "invalid"
+	ErrCodePartialWrite = "partial_write"
+	ErrCodeFailed       = "error"
+)
+
+// jsonError marks an error from a command run with --json, so main
+// reports it as an ErrorJSON document instead of plain text.
+type jsonError struct {
+	err error
+}
+
+func (e *jsonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *jsonError) Unwrap() error {
+	return e.err
+}
+
+// withJSONErrors wraps err in a jsonError when asJSON is set.
+func withJSONErrors(asJSON bool, err error) error {
+	if err == nil || !asJSON {
+		return err
+	}
+	return &jsonError{err: err}
+}
+
+// errorCode returns the --json error code for err. It follows the exit
+// code, so both always name the same kind of failure.
+func errorCode(err error) string {
+	switch exitStatus(err) {
+	case ExitNotFound:
+		return ErrCodeNotFound
+	case ExitAmbiguous:
+		return ErrCodeAmbiguous
+	case ExitNoIdentity:
+		return ErrCodeNoIdentity
+	case ExitGitMissing:
+		return ErrCodeGitMissing
+	case ExitConfigLocked:
+		return ErrCodeConfigLocked
+	case ExitInvalid:
+		return ErrCodeInvalid
+	case ExitPartialWrite:
+		return ErrCodePartialWrite
+	default:
+		return ErrCodeFailed
+	}
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func writeJSONError(w io.Writer, err error) error {
+	var doc ErrorJSON
+	doc.Version = jsonSchemaVersion
+	doc.Error.Code = errorCode(err)
+	doc.Error.Message = err.Error()
+	return writeJSON(w, doc)
+}
+
+// namedFormats are the built-in --format templates.
+var namedFormats = map[string]string{
+	"short":  `{{if .Nickname}}{{.Nickname}}{{else}}{{.Email}}{{end}}`,
+	"full":   `{{if .Nickname}}{{.Nickname}} ({{.Name}} <{{.Email}}>){{else}}{{.Name}} <{{.Email}}>{{end}}`,
+	"author": `{{.Name}} <{{.Email}}>`,
+}
+
+// formatEscapes lets formats given on the command line use \t and \n,
+// which shells do not expand inside single quotes.
+var formatEscapes = strings.NewReplacer(`\\`, `\`, `\t`, "\t", `\n`, "\n")
+
+// parseFormat parses a --format value: the name of a built-in format or a
+// text/template over the fields of IdentityJSON.
+func parseFormat(format string) (*template.Template, error) {
+	if named, found := namedFormats[format]; found {
+		format = named
+	} else {
+		format = formatEscapes.Replace(format)
+	}
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeFormat renders data through tmpl as one line of output.
+func writeFormat(w io.Writer, tmpl *template.Template, data any) error {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
+	_, err := fmt.Fprintln(w, b.String())
+	return err
+}
+
+// toIdentityJSON converts identity for --json output, marking it active
+// when it is the effective identity in current. Identities are compared
+// by ID, since several may share an email.
+func toIdentityJSON(identity Identity, current *EffectiveIdentity) IdentityJSON {
+	result := IdentityJSON{
+		ID:            identity.ID,
+		Name:          identity.Name,
+		Email:         identity.Email,
+		Nickname:      identity.Nickname,
+		SigningFormat: identity.SigningFormat,
+		SigningKey:    identity.SigningKey,
+		SSHKey:        identity.SSHKey,
+		Tags:          identity.tagList(),
+	}
+	if current != nil && current.ID == identity.ID {
+		result.Active = true
+		result.Scope = current.Scope
+		result.Origin = current.Origin
+		if current.Binding.Kind != "" {
+			result.Binding = current.Binding.condition()
+		}
+	}
+	return result
+}
+
+// activeIdentity returns the effective identity, or nil when there is
+// none. Only a missing git binary is reported as an error.
+func activeIdentity() (*EffectiveIdentity, error) {
+	current, err := getEffectiveIdentity()
+	if errors.Is(err, errGitMissing) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, nil
+	}
+	return &current, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"not found", fmt.Errorf("%w: work", errIdentityNotFound), ErrCodeNotFound},
+		{"no identity", errNoIdentity, ErrCodeNoIdentity},
+		{"git missing", &jsonError{err: errGitMissing}, ErrCodeGitMissing},
+		{"ambiguous", &ambiguousError{identifier: "john"}, ErrCodeAmbiguous},
+		{"config locked", fmt.Errorf("%w: ~/.gitconfig.lock exists", errConfigLocked), ErrCodeConfigLocked},
+		{"invalid", validationErrorf("an identity needs an email"), ErrCodeInvalid},
+		{"partial write", &partialWriteError{done: "identity saved", err: errConfigLocked}, ErrCodePartialWrite},
+		{"other", fmt.Errorf("error setting user.name: exit status 1"), ErrCodeFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := errorCode(tt.err); code != tt.expected {
+				t.Errorf("errorCode(%v) = %q, want %q", tt.err, code, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWriteJSONError(t *testing.T) {
+	var b bytes.Buffer
+	if err := writeJSONError(&b, fmt.Errorf("%w: work", errIdentityNotFound)); err != nil {
+		t.Fatalf("writeJSONError failed: %v", err)
+	}
+
+	var doc ErrorJSON
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b.String(), err)
+	}
+	if doc.Version != jsonSchemaVersion || doc.Error.Code != ErrCodeNotFound || doc.Error.Message != "identity not found: work" {
+		t.Errorf("writeJSONError wrote %+v", doc)
+	}
+}
+
+func TestListIdentitiesJSON(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	work := Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"}
+	oss := Identity{Name: "OSS User", Email: "oss@example.com"}
+	bot := Identity{Name: "Work Bot", Email: "work@example.com"}
+	for _, identity := range []Identity{work, oss, bot} {
+		if err := addIdentity(identity.Name, identity.Email, identity.Nickname); err != nil {
+			t.Fatalf("addIdentity failed: %v", err)
+		}
+	}
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
+
+	current, err := activeIdentity()
+	if err != nil || current == nil {
+		t.Fatalf("activeIdentity() = %v, %v", current, err)
+	}
+
+	results := map[string]IdentityJSON{}
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	for _, identity := range identities {
+		results[identity.Name] = toIdentityJSON(identity, current)
+	}
+
+	if got := results[work.Name]; !got.Active || got.Scope != "global" || got.Nickname != "work" {
+		t.Errorf("work identity = %+v, want active in global scope", got)
+	}
+	if got := results[oss.Name]; got.Active || got.Scope != "" || got.Origin != "" {
+		t.Errorf("oss identity = %+v, want inactive without scope", got)
+	}
+	if got := results[bot.Name]; got.Active || got.Scope != "" || got.ID == results[work.Name].ID {
+		t.Errorf("identity sharing the active email = %+v, want inactive with its own ID", got)
+	}
+
+	var b bytes.Buffer
+	if err := writeJSON(&b, IdentityResultJSON{Version: jsonSchemaVersion, Identity: results[oss.Name]}); err != nil {
+		t.Fatalf("writeJSON failed: %v", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b.String(), err)
+	}
+	identity := doc["identity"].(map[string]any)
+	for _, field := range []string{"id", "name", "email", "nickname", "active"} {
+		if _, found := identity[field]; !found {
+			t.Errorf("identity JSON is missing %q: %s", field, b.String())
+		}
+	}
+}
+
+func TestWriteFormat(t *testing.T) {
+	identity := IdentityJSON{Name: "Work User", Email: "work@example.com", Nickname: "work", Active: true, Scope: "local"}
+	anonymous := IdentityJSON{Name: "OSS User", Email: "oss@example.com"}
+
+	tests := []struct {
+		name     string
+		format   string
+		data     IdentityJSON
+		expected string
+	}{
+		{"short with nickname", "short", identity, "work\n"},
+		{"short without nickname", "short", anonymous, "oss@example.com\n"},
+		{"full", "full", identity, "work (Work User <work@example.com>)\n"},
+		{"author", "author", anonymous, "OSS User <oss@example.com>\n"},
+		{"escaped tab", `{{.Nickname}}\t{{.Email}}`, identity, "work\twork@example.com\n"},
+		{"metadata", `{{if .Active}}*{{end}}{{.Scope}}`, identity, "*local\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseFormat(tt.format)
+			if err != nil {
+				t.Fatalf("parseFormat(%q) failed: %v", tt.format, err)
+			}
+			var b bytes.Buffer
+			if err := writeFormat(&b, tmpl, tt.data); err != nil {
+				t.Fatalf("writeFormat(%q) failed: %v", tt.format, err)
+			}
+			if b.String() != tt.expected {
+				t.Errorf("writeFormat(%q) = %q, want %q", tt.format, b.String(), tt.expected)
+			}
+		})
+	}
+
+	tmpl, err := parseFormat("{{.Missing}}")
+	if err != nil {
+		t.Fatalf("parseFormat failed: %v", err)
+	}
+	if err := writeFormat(&bytes.Buffer{}, tmpl, identity); err == nil {
+		t.Error("writeFormat should fail for an unknown field")
+	}
+}
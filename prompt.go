@@ -0,0 +1,255 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func stampFile(path string) fileStamp {
+	stamp := fileStamp{Path: path}
+	if info, err := os.Stat(path); err == nil {
+		stamp.ModTime = info.ModTime().UnixNano()
+		stamp.Size = info.Size()
+	}
+	return stamp
+}
+
+func stampsCurrent(stamps []fileStamp) bool {
+	for _, stamp := range stamps {
+		if stampFile(stamp.Path) != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// findGitDir locates the repository for dir the way git does, without
+// running git: $GIT_DIR, or a .git directory or gitfile in dir or one of
+// its parents. It returns the git dir and the common dir.
+func findGitDir(dir string) (string, string, bool) {
+	gitDir := os.Getenv("GIT_DIR")
+	if gitDir == "" {
+		for {
+			candidate := filepath.Join(dir, ".git")
+			info, err := os.Stat(candidate)
+			if err == nil && info.IsDir() {
+				gitDir = candidate
+				break
+			}
+			if err == nil {
+				content, err := os.ReadFile(candidate)
+				target, found := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir: ")
+				if err == nil && found {
+					if !filepath.IsAbs(target) {
+						target = filepath.Join(dir, target)
+					}
+					gitDir = target
+					break
+				}
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return "", "", false
+			}
+			dir = parent
+		}
+	}
+
+	commonDir := gitDir
+	if content, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+	return gitDir, commonDir, true
+}
+
+// promptCacheKey identifies a cache entry by repository and by the
+// environment variables that override config.
+func promptCacheKey(gitDir string) string {
+	var env []string
+	for _, entry := range os.Environ() {
+		if strings.HasPrefix(entry, "GIT_CONFIG") || strings.HasPrefix(entry, "GIT_AUTHOR_") {
+			env = append(env, entry)
+		}
+	}
+	sort.Strings(env)
+
+	sum := sha256.Sum256([]byte(gitDir + "\x00" + strings.Join(env, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// promptCacheSize is how many repositories the prompt cache remembers.
+// It bounds the file every prompt reads, and so the cost of a cache hit.
+const promptCacheSize = 64
+
+func promptCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gitid", "prompt.json"), nil
+}
+
+func loadPromptCache(path string) map[string]promptCacheEntry {
+	cache := make(map[string]promptCacheEntry)
+	if content, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(content, &cache)
+	}
+	return cache
+}
+
+// prunePromptCache drops the entries resolved longest ago until at most
+// limit are left.
+func prunePromptCache(cache map[string]promptCacheEntry, limit int) {
+	if len(cache) <= limit {
+		return
+	}
+	keys := make([]string, 0, len(cache))
+	for key := range cache {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return cache[keys[i]].Stored < cache[keys[j]].Stored })
+	for _, key := range keys[:len(keys)-limit] {
+		delete(cache, key)
+	}
+}
+
+func savePromptCache(path string, cache map[string]promptCacheEntry) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "prompt-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// configStamps lists every file that can influence the effective identity
+// of the repository: the config files git read, the ones it would read if
+// they existed, and HEAD for onbranch bindings.
+func configStamps(gitDir, commonDir string) []fileStamp {
+	paths := []string{
+		filepath.Join(gitDir, "HEAD"),
+		filepath.Join(gitDir, "config.worktree"),
+		filepath.Join(commonDir, "config"),
+	}
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		paths = append(paths, global)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(configDir, "git", "config"))
+	}
+
+	out, _ := exec.Command("git", "config", "--list", "--show-origin", "--null").Output()
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if origin, found := strings.CutPrefix(entry, "file:"); found {
+			if abs, err := filepath.Abs(origin); err == nil {
+				paths = append(paths, abs)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var stamps []fileStamp
+	for _, path := range paths {
+		if !seen[path] {
+			seen[path] = true
+			stamps = append(stamps, stampFile(path))
+		}
+	}
+	return stamps
+}
+
+// promptIdentity resolves the effective identity for the current
+// directory, answering from the cache while none of the files it depends
+// on changed. It reports false outside git repositories or when no
+// identity is configured.
+func promptIdentity() (EffectiveIdentity, bool) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return EffectiveIdentity{}, false
+	}
+	gitDir, commonDir, found := findGitDir(wd)
+	if !found {
+		return EffectiveIdentity{}, false
+	}
+	if abs, err := filepath.Abs(gitDir); err == nil {
+		gitDir = abs
+	}
+
+	key := promptCacheKey(gitDir)
+	cachePath, cacheErr := promptCachePath()
+	var cache map[string]promptCacheEntry
+	if cacheErr == nil {
+		cache = loadPromptCache(cachePath)
+		if entry, found := cache[key]; found && stampsCurrent(entry.Stamps) {
+			return entry.Identity, entry.Identity.Email != ""
+		}
+	}
+
+	// Stamp before resolving so a config change made in between is
+	// picked up on the next run rather than lost.
+	stamps := configStamps(gitDir, commonDir)
+	current, err := getEffectiveIdentity()
+	if err != nil {
+		current = EffectiveIdentity{}
+	}
+
+	if cacheErr == nil {
+		cache[key] = promptCacheEntry{Identity: current, Stamps: stamps, Stored: time.Now().UnixNano()}
+		prunePromptCache(cache, promptCacheSize)
+		savePromptCache(cachePath, cache)
+	}
+	return current, current.Email != ""
+}
+
+// renderPrompt renders format, the short format by default, for the
+// effective identity, or returns an empty string outside git repositories.
+func renderPrompt(format string) (string, error) {
+	if format == "" {
+		format = "short"
+	}
+	tmpl, err := parseFormat(format)
+	if err != nil {
+		return "", err
+	}
+
+	current, found := promptIdentity()
+	if !found {
+		return "", nil
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, toIdentityJSON(current.Identity, &current)); err != nil {
+		return "", fmt.Errorf("invalid format: %w", err)
+	}
+	return b.String(), nil
+}
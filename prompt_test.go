@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestRenderPromptOutsideRepository(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	if err := switchIdentity(Identity{Name: "Global User", Email: "global@example.com"}, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	output, err := renderPrompt("")
+	if err != nil {
+		t.Fatalf("renderPrompt failed: %v", err)
+	}
+	if output != "" {
+		t.Errorf("renderPrompt outside a repository = %q, want empty", output)
+	}
+}
+
+func TestRenderPrompt(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	dir := setupTestRepo(t)
+
+	work := Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"}
+	oss := Identity{Name: "OSS User", Email: "oss@example.com"}
+	for _, identity := range []Identity{work, oss} {
+		if err := addIdentity(identity.Name, identity.Email, identity.Nickname); err != nil {
+			t.Fatalf("addIdentity failed: %v", err)
+		}
+	}
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		switchTo *Identity
+		format   string
+		expected string
+	}{
+		{"default format uses nickname", nil, "", "work"},
+		{"custom format", nil, "{{.Name}} ({{.Scope}})", "Work User (global)"},
+		{"local switch invalidates cache", &oss, "", "oss@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.switchTo != nil {
+				if err := switchIdentity(*tt.switchTo, ConfigTarget{Scope: ScopeLocal}); err != nil {
+					t.Fatalf("switchIdentity failed: %v", err)
+				}
+			}
+			output, err := renderPrompt(tt.format)
+			if err != nil {
+				t.Fatalf("renderPrompt(%q) failed: %v", tt.format, err)
+			}
+			if output != tt.expected {
+				t.Errorf("renderPrompt(%q) = %q, want %q", tt.format, output, tt.expected)
+			}
+		})
+	}
+
+	sub := filepath.Join(dir, "sub", "dir")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	if output, _ := renderPrompt(""); output != "oss@example.com" {
+		t.Errorf("renderPrompt in subdirectory = %q, want %q", output, "oss@example.com")
+	}
+
+	// A warm cache answers without running git at all.
+	t.Setenv("PATH", "")
+	if _, err := exec.LookPath("git"); err == nil {
+		t.Fatal("git should not be reachable with an empty PATH")
+	}
+	if output, _ := renderPrompt(""); output != "oss@example.com" {
+		t.Errorf("renderPrompt from cache = %q, want %q", output, "oss@example.com")
+	}
+}
+
+func TestPrunePromptCache(t *testing.T) {
+	cache := make(map[string]promptCacheEntry)
+	for i := 0; i < 5; i++ {
+		cache[fmt.Sprint(i)] = promptCacheEntry{Stored: int64(i)}
+	}
+	cache["legacy"] = promptCacheEntry{}
+
+	prunePromptCache(cache, 3)
+	var kept []string
+	for key := range cache {
+		kept = append(kept, key)
+	}
+	sort.Strings(kept)
+	if expected := []string{"2", "3", "4"}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("kept %v, want the newest entries %v", kept, expected)
+	}
+}
+
+func TestPromptCacheIsBounded(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	switchIdentity(Identity{Name: "Global User", Email: "global@example.com"}, globalTarget)
+
+	for i := 0; i < promptCacheSize+3; i++ {
+		setupTestRepo(t)
+		renderPrompt("")
+	}
+	path, _ := promptCachePath()
+	if n := len(loadPromptCache(path)); n != promptCacheSize {
+		t.Errorf("prompt cache holds %d repositories, want at most %d", n, promptCacheSize)
+	}
+}
+
+func TestRenderPromptInvalidFormat(t *testing.T) {
+	if _, err := renderPrompt("{{.Name"); err == nil {
+		t.Error("renderPrompt should reject an invalid template")
+	}
+}
+
+// BenchmarkPromptCacheHit measures gitid prompt in a repository whose
+// identity is cached, with the cache full. It runs on every shell prompt,
+// so it should stay well under 10ms.
+func BenchmarkPromptCacheHit(b *testing.B) {
+	cleanup := setupTestGitConfig(b)
+	defer cleanup()
+	switchIdentity(Identity{Name: "Global User", Email: "global@example.com"}, globalTarget)
+
+	for i := 0; i < promptCacheSize; i++ {
+		setupTestRepo(b)
+		renderPrompt("")
+	}
+	b.Setenv("PATH", "")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if output, _ := renderPrompt(""); output != "global@example.com" {
+			b.Fatalf("renderPrompt = %q, want a cache hit", output)
+		}
+	}
+}
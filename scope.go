@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var globalTarget = ConfigTarget{Scope: ScopeGlobal}
+
+func (t ConfigTarget) flags() []string {
+	if t.Scope == ScopeFile {
+		return []string{"--file", t.File}
+	}
+	if t.Scope == "" {
+		return []string{"--global"}
+	}
+	return []string{"--" + string(t.Scope)}
+}
+
+func (t ConfigTarget) String() string {
+	if t.Scope == ScopeFile {
+		return "file " + t.File
+	}
+	if t.Scope == "" {
+		return string(ScopeGlobal)
+	}
+	return string(t.Scope)
+}
+
+func gitConfigAt(target ConfigTarget, args ...string) *exec.Cmd {
+	return exec.Command("git", append(append([]string{"config"}, target.flags()...), args...)...)
+}
+
+func getConfigAt(target ConfigTarget, key string) string {
+	value, _ := lookupConfigAt(target, key)
+	return value
+}
+
+// lookupConfigAt reads key from target and reports whether it is set, so
+// that an empty value can be told apart from a missing one.
+func lookupConfigAt(target ConfigTarget, key string) (string, bool) {
+	value, found, err := currentConfigBackend().Get(target, key)
+	if err != nil {
+		return "", false
+	}
+	return value, found
+}
+
+func setConfigAt(target ConfigTarget, key, value string) error {
+	return currentConfigBackend().Set(target, key, value)
+}
+
+// configRegexpAt returns the variables of target whose key matches
+// pattern, in file order.
+func configRegexpAt(target ConfigTarget, pattern string) ([]configEntry, error) {
+	return currentConfigBackend().GetRegexp(target, pattern)
+}
+
+// parseScopeFlags strips --global, --local, --worktree and --file <path>
+// from args and returns the selected target along with the remaining
+// arguments. Without any scope flag the target is def.
+func parseScopeFlags(args []string, def ConfigTarget) (ConfigTarget, []string, error) {
+	target := def
+	seen := false
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		var next ConfigTarget
+		switch arg := args[i]; {
+		case arg == "--global":
+			next = ConfigTarget{Scope: ScopeGlobal}
+		case arg == "--local":
+			next = ConfigTarget{Scope: ScopeLocal}
+		case arg == "--worktree":
+			next = ConfigTarget{Scope: ScopeWorktree}
+		case arg == "--file":
+			if i+1 >= len(args) {
+				return target, nil, fmt.Errorf("--file requires a path")
+			}
+			i++
+			next = ConfigTarget{Scope: ScopeFile, File: args[i]}
+		case strings.HasPrefix(arg, "--file="):
+			next = ConfigTarget{Scope: ScopeFile, File: strings.TrimPrefix(arg, "--file=")}
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+
+		if seen {
+			return target, nil, fmt.Errorf("only one of --global, --local, --worktree or --file may be given")
+		}
+		seen = true
+		target = next
+	}
+
+	return target, rest, nil
+}
+
+// errNoIdentity is returned when git has no user.name or user.email for
+// the current directory.
+var errNoIdentity = errors.New("no git identity configured")
+
+// getEffectiveIdentity asks git which user.name/user.email apply in the
+// current directory, honouring local, worktree and included config.
+func getEffectiveIdentity() (EffectiveIdentity, error) {
+	backend := currentConfigBackend()
+	emailEntry, scope, found, err := backend.Effective("user.email")
+	if err != nil || !found {
+		return EffectiveIdentity{}, gitError(err, errNoIdentity)
+	}
+	nameEntry, _, found, err := backend.Effective("user.name")
+	if err != nil || !found {
+		return EffectiveIdentity{}, gitError(err, errNoIdentity)
+	}
+
+	name, email := strings.TrimSpace(nameEntry.Value), emailEntry.Value
+	stored, _, err := lookupIdentity(name, email)
+	if err != nil {
+		return EffectiveIdentity{}, err
+	}
+	current := EffectiveIdentity{
+		Identity: Identity{
+			ID:       stored.ID,
+			Name:     name,
+			Email:    email,
+			Nickname: stored.Nickname,
+		},
+		Scope:  scope,
+		Origin: emailEntry.Origin,
+	}
+	current.Binding, _ = explainOrigin(current.Origin)
+	return current, nil
+}
+
+// unsetConfigAt removes every value of key from target. It reports whether
+// the key was set at all.
+func unsetConfigAt(target ConfigTarget, key string) (bool, error) {
+	unset, err := currentConfigBackend().UnsetAll(target, key)
+	if err != nil {
+		return false, fmt.Errorf("error removing %s from %s config: %w", key, target, err)
+	}
+	return unset, nil
+}
+
+// unpinIdentity removes the keys a switch writes from target so that the
+// identity from a wider scope applies again. It reports whether anything
+// was removed.
+func unpinIdentity(target ConfigTarget) (bool, error) {
+	removed := false
+	err := updateConfig(target, func(f *configFile) error {
+		before := f.content
+		if err := applySSHCommand(f, Identity{}, target); err != nil {
+			return err
+		}
+		keys := append([]string{"user.name", "user.email"}, signingConfigKeys...)
+		for _, key := range keys {
+			if _, err := f.unset(key); err != nil {
+				return fmt.Errorf("error removing %s from %s config: %w", key, target, err)
+			}
+		}
+		removed = f.content != before
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
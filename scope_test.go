@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupTestRepo(t testing.TB) string {
+	dir := t.TempDir()
+	if err := exec.Command("git", "init", "-q", dir).Run(); err != nil {
+		t.Fatalf("git init failed: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return dir
+}
+
+func TestParseScopeFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected ConfigTarget
+		rest     []string
+		wantErr  bool
+	}{
+		{"default", []string{"work"}, globalTarget, []string{"work"}, false},
+		{"local", []string{"--local", "work"}, ConfigTarget{Scope: ScopeLocal}, []string{"work"}, false},
+		{"worktree after id", []string{"work", "--worktree"}, ConfigTarget{Scope: ScopeWorktree}, []string{"work"}, false},
+		{"file", []string{"--file", "/tmp/cfg", "work"}, ConfigTarget{Scope: ScopeFile, File: "/tmp/cfg"}, []string{"work"}, false},
+		{"file equals", []string{"--file=/tmp/cfg", "work"}, ConfigTarget{Scope: ScopeFile, File: "/tmp/cfg"}, []string{"work"}, false},
+		{"file missing path", []string{"--file"}, ConfigTarget{}, nil, true},
+		{"conflicting", []string{"--local", "--global", "work"}, ConfigTarget{}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, rest, err := parseScopeFlags(tt.args, globalTarget)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseScopeFlags(%q) should fail", tt.args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseScopeFlags(%q) failed: %v", tt.args, err)
+			}
+			if target != tt.expected {
+				t.Errorf("parseScopeFlags(%q) target = %+v, want %+v", tt.args, target, tt.expected)
+			}
+			if !reflect.DeepEqual(rest, tt.rest) {
+				t.Errorf("parseScopeFlags(%q) rest = %q, want %q", tt.args, rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestSwitchIdentityLocalAndUnpin(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	global := Identity{Name: "Global User", Email: "global@example.com"}
+	local := Identity{Name: "Local User", Email: "local@example.com"}
+
+	if err := switchIdentity(global, globalTarget); err != nil {
+		t.Fatalf("switchIdentity global failed: %v", err)
+	}
+	if err := switchIdentity(local, ConfigTarget{Scope: ScopeLocal}); err != nil {
+		t.Fatalf("switchIdentity local failed: %v", err)
+	}
+
+	current, err := getEffectiveIdentity()
+	if err != nil {
+		t.Fatalf("getEffectiveIdentity failed: %v", err)
+	}
+	if current.Email != local.Email || current.Scope != "local" {
+		t.Errorf("effective identity = %+v, want local %s", current, local.Email)
+	}
+	if filepath.Base(current.Origin) != "config" {
+		t.Errorf("effective identity origin = %q, want repository config", current.Origin)
+	}
+
+	removed, err := unpinIdentity(ConfigTarget{Scope: ScopeLocal})
+	if err != nil {
+		t.Fatalf("unpinIdentity failed: %v", err)
+	}
+	if !removed {
+		t.Error("unpinIdentity should report the local identity as removed")
+	}
+
+	current, err = getEffectiveIdentity()
+	if err != nil {
+		t.Fatalf("getEffectiveIdentity failed: %v", err)
+	}
+	if current.Email != global.Email || current.Scope != "global" {
+		t.Errorf("effective identity after unpin = %+v, want global %s", current, global.Email)
+	}
+
+	removed, err = unpinIdentity(ConfigTarget{Scope: ScopeLocal})
+	if err != nil {
+		t.Fatalf("second unpinIdentity failed: %v", err)
+	}
+	if removed {
+		t.Error("unpinIdentity should report nothing removed when no identity is pinned")
+	}
+}
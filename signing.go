@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Signing formats, named after the gpg.format values git accepts.
+const (
+	SigningSSH     = "ssh"
+	SigningOpenPGP = "openpgp"
+	SigningX509    = "x509"
+)
+
+// signingConfigKeys are the git settings a switch manages for signing.
+var signingConfigKeys = []string{"gpg.format", "user.signingkey", "commit.gpgsign", "tag.gpgsign"}
+
+// validateSigningKey checks that key is usable for format. SSH keys may be
+// given as a path to a public key file or as a "key::" literal; OpenPGP and
+// X.509 keys must be in the local keyring with a user ID for email.
+func validateSigningKey(format, key, email string) error {
+	switch format {
+	case SigningSSH:
+		// Without git there is no version to check; the native backend
+		// still writes the config for whichever git reads it later.
+		if err := requireGitVersion(2, 34, "SSH commit signing"); err != nil && !errors.Is(err, errGitMissing) {
+			return err
+		}
+		if strings.HasPrefix(key, "key::") {
+			return nil
+		}
+		path, err := expandHome(key)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return validationErrorf("SSH signing key not found: %s", key)
+		}
+		return nil
+	case SigningOpenPGP, SigningX509:
+		return validateKeyringKey(format, key, email)
+	default:
+		return validationErrorf("unsupported signing format: %s", format)
+	}
+}
+
+// withSigningKey returns identity signing with key after validating it.
+// SSH key paths are stored absolute. An empty key turns signing off.
+func withSigningKey(identity Identity, format, key string) (Identity, error) {
+	if key == "" {
+		identity.SigningFormat, identity.SigningKey = "", ""
+		return identity, nil
+	}
+	if err := validateSigningKey(format, key, identity.Email); err != nil {
+		return identity, err
+	}
+	if format == SigningSSH && !strings.HasPrefix(key, "key::") {
+		path, err := expandHome(key)
+		if err != nil {
+			return identity, err
+		}
+		if key, err = filepath.Abs(path); err != nil {
+			return identity, fmt.Errorf("error resolving %s: %w", path, err)
+		}
+	}
+	identity.SigningFormat, identity.SigningKey = format, key
+	return identity, nil
+}
+
+// applySigning writes identity's signing configuration to f, the config
+// file of target. For an identity without a key the signing settings are
+// removed; outside the global scope signing is also turned off explicitly
+// so a key configured globally is not used for this identity's commits.
+// The key itself is validated by the caller.
+func applySigning(f *configFile, identity Identity, target ConfigTarget) error {
+	if identity.SigningKey == "" {
+		for _, key := range []string{"gpg.format", "user.signingkey"} {
+			if _, err := f.unset(key); err != nil {
+				return fmt.Errorf("error removing %s from %s config: %w", key, target, err)
+			}
+		}
+		for _, key := range []string{"commit.gpgsign", "tag.gpgsign"} {
+			var err error
+			if target.Scope == ScopeGlobal {
+				_, err = f.unset(key)
+			} else {
+				err = f.set(key, "false")
+			}
+			if err != nil {
+				return fmt.Errorf("error disabling %s in %s config: %w", key, target, err)
+			}
+		}
+		return nil
+	}
+
+	settings := [][2]string{
+		{"gpg.format", identity.SigningFormat},
+		{"user.signingkey", identity.SigningKey},
+		{"commit.gpgsign", "true"},
+		{"tag.gpgsign", "true"},
+	}
+	for _, setting := range settings {
+		if err := f.set(setting[0], setting[1]); err != nil {
+			return fmt.Errorf("error setting %s in %s config: %w", setting[0], target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gitConfigValue(t *testing.T, args ...string) string {
+	t.Helper()
+	out, _ := exec.Command("git", append([]string{"config"}, args...)...).Output()
+	return strings.TrimSpace(string(out))
+}
+
+func TestSwitchIdentitySSHSigning(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	keyPath := filepath.Join(os.Getenv("HOME"), "id_work.pub")
+	os.WriteFile(keyPath, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGitidTestKey work@example.com\n"), 0o644)
+
+	addIdentity("Work User", "work@example.com", "work")
+	addIdentity("Personal", "me@example.com", "me")
+	work, _ := findIdentityByIdentifier(t, "work")
+	work, err := withSigningKey(work, SigningSSH, "~/id_work.pub")
+	if err != nil {
+		t.Fatalf("withSigningKey failed: %v", err)
+	}
+	if err := (gitConfigStore{}).Put(work); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	work, _ = findIdentityByIdentifier(t, "work")
+	if work.SigningFormat != SigningSSH || work.SigningKey != keyPath {
+		t.Errorf("stored signing config = %q %q, want %q %q", work.SigningFormat, work.SigningKey, SigningSSH, keyPath)
+	}
+
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switchIdentity(work) failed: %v", err)
+	}
+	expected := map[string]string{
+		"gpg.format":      "ssh",
+		"user.signingkey": keyPath,
+		"commit.gpgsign":  "true",
+		"tag.gpgsign":     "true",
+	}
+	for key, value := range expected {
+		if got := gitConfigValue(t, "--global", key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+
+	personal, _ := findIdentityByIdentifier(t, "me")
+	if err := switchIdentity(personal, globalTarget); err != nil {
+		t.Fatalf("switchIdentity(personal) failed: %v", err)
+	}
+	for _, key := range signingConfigKeys {
+		if got := gitConfigValue(t, "--global", key); got != "" {
+			t.Errorf("%s = %q after switching to unsigned identity, want unset", key, got)
+		}
+	}
+}
+
+func TestSwitchIdentityUnsignedLocalDisablesSigning(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+	setupTestRepo(t)
+
+	exec.Command("git", "config", "--global", "commit.gpgsign", "true").Run()
+
+	if err := switchIdentity(Identity{Name: "Personal", Email: "me@example.com"}, ConfigTarget{Scope: ScopeLocal}); err != nil {
+		t.Fatalf("switchIdentity failed: %v", err)
+	}
+	if got := gitConfigValue(t, "commit.gpgsign"); got != "false" {
+		t.Errorf("effective commit.gpgsign = %q, want false", got)
+	}
+}
+
+func TestWithSigningKeyMissingFile(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	work := Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"}
+	if _, err := withSigningKey(work, SigningSSH, "~/missing.pub"); err == nil {
+		t.Error("withSigningKey should fail for a missing key file")
+	}
+	if _, err := withSigningKey(work, SigningSSH, "key::ssh-ed25519 AAAA"); err != nil {
+		t.Errorf("withSigningKey with key:: literal failed: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Keys gitid uses to remember which core.sshCommand it wrote and what was
+// configured before, so switching away can put the old value back.
+const (
+	managedSSHCommandKey  = "gitid.sshcommand"
+	previousSSHCommandKey = "gitid.previoussshcommand"
+)
+
+// shellQuote quotes s for POSIX shells, leaving simple words untouched.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sshCommandFor(key string) string {
+	return fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes", shellQuote(key))
+}
+
+// withSSHKey returns identity authenticating with the private key at
+// key, stored as an absolute path. An empty key removes it.
+func withSSHKey(identity Identity, key string) (Identity, error) {
+	if key == "" {
+		identity.SSHKey = ""
+		return identity, nil
+	}
+	path, err := expandHome(key)
+	if err != nil {
+		return identity, err
+	}
+	if key, err = filepath.Abs(path); err != nil {
+		return identity, fmt.Errorf("error resolving %s: %w", path, err)
+	}
+	if _, err := os.Stat(key); err != nil {
+		return identity, validationErrorf("SSH key not found: %s", key)
+	}
+	identity.SSHKey = key
+	return identity, nil
+}
+
+// applySSHCommand points core.sshCommand in f, the config file of target,
+// at identity's key. A core.sshCommand that gitid did not write is saved
+// first and restored once an identity without a key is switched to.
+func applySSHCommand(f *configFile, identity Identity, target ConfigTarget) error {
+	current, _ := f.get("core.sshCommand")
+	managed, _ := f.get(managedSSHCommandKey)
+	ours := current != "" && current == managed
+
+	if identity.SSHKey == "" {
+		if ours {
+			if previous, _ := f.get(previousSSHCommandKey); previous != "" {
+				if err := f.set("core.sshCommand", previous); err != nil {
+					return fmt.Errorf("error restoring core.sshCommand in %s config: %w", target, err)
+				}
+			} else if _, err := f.unset("core.sshCommand"); err != nil {
+				return fmt.Errorf("error removing core.sshCommand from %s config: %w", target, err)
+			}
+		}
+		for _, key := range []string{managedSSHCommandKey, previousSSHCommandKey} {
+			if _, err := f.unset(key); err != nil {
+				return fmt.Errorf("error removing %s from %s config: %w", key, target, err)
+			}
+		}
+		return nil
+	}
+
+	if current != "" && !ours {
+		if err := f.set(previousSSHCommandKey, current); err != nil {
+			return fmt.Errorf("error saving core.sshCommand in %s config: %w", target, err)
+		}
+	}
+
+	command := sshCommandFor(identity.SSHKey)
+	if err := f.set("core.sshCommand", command); err != nil {
+		return fmt.Errorf("error setting core.sshCommand in %s config: %w", target, err)
+	}
+	if err := f.set(managedSSHCommandKey, command); err != nil {
+		return fmt.Errorf("error setting core.sshCommand in %s config: %w", target, err)
+	}
+	return nil
+}
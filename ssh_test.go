@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"/home/me/.ssh/id_work", "/home/me/.ssh/id_work"},
+		{"/home/me/my keys/id", "'/home/me/my keys/id'"},
+		{"it's", `'it'\''s'`},
+		{"", "''"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if result := shellQuote(tt.input); result != tt.expected {
+				t.Errorf("shellQuote(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSwitchIdentitySSHCommand(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	keyPath := filepath.Join(os.Getenv("HOME"), "id_work")
+	os.WriteFile(keyPath, []byte("not a real key\n"), 0o600)
+
+	exec.Command("git", "config", "--global", "core.sshCommand", "ssh -F ~/.ssh/custom_config").Run()
+
+	addIdentity("Work User", "work@example.com", "work")
+	addIdentity("Personal", "me@example.com", "me")
+	work, _ := findIdentityByIdentifier(t, "work")
+	work, err := withSSHKey(work, "~/id_work")
+	if err != nil {
+		t.Fatalf("withSSHKey failed: %v", err)
+	}
+	if err := (gitConfigStore{}).Put(work); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	work, _ = findIdentityByIdentifier(t, "work")
+	if work.SSHKey != keyPath {
+		t.Errorf("stored SSH key = %q, want %q", work.SSHKey, keyPath)
+	}
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("switchIdentity(work) failed: %v", err)
+	}
+	if got, want := gitConfigValue(t, "--global", "core.sshCommand"), sshCommandFor(keyPath); got != want {
+		t.Errorf("core.sshCommand = %q, want %q", got, want)
+	}
+
+	// Switching between identities with keys must not save gitid's own
+	// command as the one to restore.
+	if err := switchIdentity(work, globalTarget); err != nil {
+		t.Fatalf("second switchIdentity(work) failed: %v", err)
+	}
+
+	personal, _ := findIdentityByIdentifier(t, "me")
+	if err := switchIdentity(personal, globalTarget); err != nil {
+		t.Fatalf("switchIdentity(personal) failed: %v", err)
+	}
+	if got := gitConfigValue(t, "--global", "core.sshCommand"); got != "ssh -F ~/.ssh/custom_config" {
+		t.Errorf("core.sshCommand after switching away = %q, want the original command", got)
+	}
+	if got := gitConfigValue(t, "--global", previousSSHCommandKey); got != "" {
+		t.Errorf("%s = %q after restoring, want unset", previousSSHCommandKey, got)
+	}
+}
+
+func TestWithSSHKeyMissingFile(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	work := Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"}
+	if _, err := withSSHKey(work, "~/missing_key"); err == nil {
+		t.Error("withSSHKey should fail for a missing key file")
+	}
+}
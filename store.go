@@ -0,0 +1,168 @@
+package main
+
+import "fmt"
+
+func (gitConfigStore) List() ([]Identity, error) {
+	return getAllIdentities()
+}
+
+func (s gitConfigStore) Get(id string) (Identity, bool, error) {
+	identities, err := s.List()
+	if err != nil {
+		return Identity{}, false, err
+	}
+	for _, identity := range identities {
+		if identity.ID == id {
+			return identity, true, nil
+		}
+	}
+	return Identity{}, false, nil
+}
+
+func (gitConfigStore) Put(identity Identity) error {
+	_, err := putIdentity(identity)
+	return err
+}
+
+func (gitConfigStore) Delete(id string) error {
+	return deleteIdentity(id)
+}
+
+func (gitConfigStore) SetActive(identity Identity, target ConfigTarget) error {
+	return switchIdentity(identity, target)
+}
+
+// newMemoryStore returns a memoryStore holding identities. Identities
+// without an ID are given one.
+func newMemoryStore(identities ...Identity) *memoryStore {
+	store := &memoryStore{active: make(map[ConfigTarget]Identity)}
+	for _, identity := range identities {
+		store.Put(identity)
+	}
+	return store
+}
+
+// snapshotStore copies the catalog of store into a memoryStore, so that
+// changes can be tried without writing anything.
+func snapshotStore(store IdentityStore) (*memoryStore, error) {
+	identities, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	return newMemoryStore(identities...), nil
+}
+
+func (s *memoryStore) List() ([]Identity, error) {
+	return append([]Identity{}, s.identities...), nil
+}
+
+func (s *memoryStore) Get(id string) (Identity, bool, error) {
+	for _, identity := range s.identities {
+		if identity.ID == id {
+			return identity, true, nil
+		}
+	}
+	return Identity{}, false, nil
+}
+
+func (s *memoryStore) Put(identity Identity) error {
+	if identity.ID == "" {
+		s.lastID++
+		identity.ID = fmt.Sprintf("memory-%d", s.lastID)
+		s.identities = append(s.identities, identity)
+		return nil
+	}
+	for i := range s.identities {
+		if s.identities[i].ID == identity.ID {
+			s.identities[i] = identity
+			return nil
+		}
+	}
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	for i, identity := range s.identities {
+		if identity.ID == id {
+			s.identities = append(s.identities[:i], s.identities[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errIdentityNotFound, id)
+}
+
+func (s *memoryStore) SetActive(identity Identity, target ConfigTarget) error {
+	s.active[target] = identity
+	return nil
+}
+
+// addToStore stores identity in store as a new identity. One with the
+// same name and email already being stored is an error.
+func addToStore(store IdentityStore, identity Identity) error {
+	if err := validateIdentity(identity); err != nil {
+		return err
+	}
+	identities, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, existing := range identities {
+		if existing.Name == identity.Name && existing.Email == identity.Email {
+			return validationErrorf("identity already exists: %s", getIdentityDisplay(existing))
+		}
+	}
+	identity.ID = ""
+	return store.Put(identity)
+}
+
+// updateInStore replaces the stored identity with identity's ID. Another
+// identity already having its name and email is an error.
+func updateInStore(store IdentityStore, identity Identity) error {
+	if err := validateIdentity(identity); err != nil {
+		return err
+	}
+	identities, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, other := range identities {
+		if other.ID != identity.ID && other.Name == identity.Name && other.Email == identity.Email {
+			return validationErrorf("identity already exists: %s", getIdentityDisplay(other))
+		}
+	}
+	return store.Put(identity)
+}
+
+// findIdentity looks identifier up in store; see matchIdentities. An
+// exactStore only accepts exact matches. When several identities match,
+// or only one does loosely, the user picks one of them on a terminal;
+// otherwise, or when the user cancels, an *ambiguousError listing them is
+// returned.
+func findIdentity(store IdentityStore, identifier string) (Identity, error) {
+	identities, err := store.List()
+	if err != nil {
+		return Identity{}, err
+	}
+	_, exact := store.(exactStore)
+	candidates, sure := matchIdentities(identities, identifier, exact)
+	switch {
+	case len(candidates) == 0:
+		return Identity{}, fmt.Errorf("%w: %s", errIdentityNotFound, identifier)
+	case len(candidates) == 1 && sure:
+		return candidates[0], nil
+	case isInteractive():
+		title := fmt.Sprintf("%q matches several identities", identifier)
+		if len(candidates) == 1 {
+			title = fmt.Sprintf("%q only loosely matches", identifier)
+		}
+		identity, ok, err := pickIdentity(title, identifier, candidates)
+		if err != nil {
+			return Identity{}, err
+		}
+		if ok {
+			return identity, nil
+		}
+	}
+	return Identity{}, &ambiguousError{identifier: identifier, candidates: candidates}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIdentityStores(t *testing.T) {
+	stores := []struct {
+		name  string
+		store func(t *testing.T) IdentityStore
+	}{
+		{"memory", func(t *testing.T) IdentityStore { return newMemoryStore() }},
+		{"git config", func(t *testing.T) IdentityStore {
+			t.Cleanup(setupTestGitConfig(t))
+			return gitConfigStore{}
+		}},
+	}
+
+	work := Identity{Name: "Work User", Email: "work@example.com", Nickname: "work", SigningFormat: SigningSSH, SigningKey: "key::ssh-ed25519 AAAA", Tags: "acme,oncall"}
+	oss := Identity{Name: "OSS User", Email: "oss@example.com"}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			store := tt.store(t)
+			for _, identity := range []Identity{work, oss} {
+				if err := store.Put(identity); err != nil {
+					t.Fatalf("Put(%s) failed: %v", identity.Email, err)
+				}
+			}
+			identities, err := store.List()
+			if err != nil || len(identities) != 2 || identities[0].ID == "" || identities[0].ID == identities[1].ID {
+				t.Fatalf("List = %+v, %v, want two identities with distinct IDs", identities, err)
+			}
+			work.ID, oss.ID = identities[0].ID, identities[1].ID
+
+			got, found, err := store.Get(work.ID)
+			if err != nil || !found || !reflect.DeepEqual(got, work) {
+				t.Errorf("Get(%s) = %+v, %v, %v, want %+v", work.ID, got, found, err, work)
+			}
+
+			renamed := work
+			renamed.Nickname = ""
+			renamed.SigningFormat, renamed.SigningKey, renamed.Tags = "", "", ""
+			if err := store.Put(renamed); err != nil {
+				t.Fatalf("Put replacing %s failed: %v", work.Email, err)
+			}
+			if got, _, _ := store.Get(work.ID); !reflect.DeepEqual(got, renamed) {
+				t.Errorf("Put should replace every attribute, got %+v", got)
+			}
+
+			if err := store.Delete(oss.ID); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			identities, err = store.List()
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(identities) != 1 || identities[0].Email != work.Email {
+				t.Errorf("List after delete = %+v, want only %s", identities, work.Email)
+			}
+
+			if _, err := findIdentity(store, "nobody"); !errors.Is(err, errIdentityNotFound) {
+				t.Errorf("findIdentity(nobody) error = %v, want errIdentityNotFound", err)
+			}
+		})
+	}
+}
+
+func TestDryRunCLI(t *testing.T) {
+	cleanup := setupTestGitConfig(t)
+	defer cleanup()
+
+	store := gitConfigStore{}
+	if err := addIdentity("Work User", "work@example.com", "work"); err != nil {
+		t.Fatalf("addIdentity failed: %v", err)
+	}
+
+	commands := [][]string{
+		{"--dry-run", "add", "OSS User", "oss@example.com", "oss"},
+		{"--dry-run", "nickname", "work", "job"},
+		{"--dry-run", "switch", "work"},
+		{"--dry-run", "delete", "work"},
+	}
+	for _, args := range commands {
+		if err := handleCLICommand(store, args); err != nil {
+			t.Fatalf("handleCLICommand(%q) failed: %v", args, err)
+		}
+	}
+
+	identities, err := getAllIdentities()
+	if err != nil {
+		t.Fatalf("getAllIdentities failed: %v", err)
+	}
+	if len(identities) != 1 || identities[0].Nickname != "work" {
+		t.Errorf("dry runs should not change the catalog, got %+v", identities)
+	}
+	if email := getConfigAt(globalTarget, "user.email"); email != "" {
+		t.Errorf("dry run switch set user.email to %q", email)
+	}
+
+	if err := handleCLICommand(store, []string{"--dry-run", "bind", "/tmp", "work"}); err == nil {
+		t.Error("--dry-run should refuse commands outside the identity store")
+	}
+}
+
+func TestMemoryStoreCLI(t *testing.T) {
+	store := newMemoryStore(Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"})
+
+	if err := handleCLICommand(store, []string{"switch", "--local", "work"}); err != nil {
+		t.Fatalf("switch failed: %v", err)
+	}
+	if active := store.active[ConfigTarget{Scope: ScopeLocal}]; active.Email != "work@example.com" {
+		t.Errorf("local active identity = %+v, want work@example.com", active)
+	}
+
+	if err := handleCLICommand(store, []string{"nickname", "work@example.com", "job"}); err != nil {
+		t.Fatalf("nickname failed: %v", err)
+	}
+	if identity, _ := findIdentity(store, "work@example.com"); identity.Nickname != "job" {
+		t.Errorf("nickname = %q, want job", identity.Nickname)
+	}
+
+	if err := handleCLICommand(store, []string{"tag", "job", "acme", "oncall", "acme"}); err != nil {
+		t.Fatalf("tag failed: %v", err)
+	}
+	if identity, _ := findIdentity(store, "acme"); identity.Tags != "acme,oncall" {
+		t.Errorf("tags = %q, want acme,oncall", identity.Tags)
+	}
+	if err := handleCLICommand(store, []string{"tag", "job", "--remove", "acme"}); err != nil {
+		t.Fatalf("tag --remove failed: %v", err)
+	}
+	if identity, _ := findIdentity(store, "job"); identity.Tags != "oncall" {
+		t.Errorf("tags after --remove = %q, want oncall", identity.Tags)
+	}
+	if err := handleCLICommand(store, []string{"tag", "job", "a,b"}); exitStatus(err) != ExitInvalid {
+		t.Errorf("tag with a comma error = %v, want a validation error", err)
+	}
+
+	if err := handleCLICommand(store, []string{"find", "onc"}); err != nil {
+		t.Errorf("find onc failed: %v", err)
+	}
+	if err := handleCLICommand(store, []string{"--exact", "find", "onc"}); !errors.Is(err, errIdentityNotFound) {
+		t.Errorf("--exact find onc error = %v, want errIdentityNotFound", err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin, _ = os.Open(os.DevNull)
+	defer func() { os.Stdin = stdin }()
+	if err := handleCLICommand(store, []string{"delete", "wk"}); exitStatus(err) != ExitAmbiguous {
+		t.Errorf("delete wk error = %v, want it refused as ambiguous", err)
+	}
+	if identities, _ := store.List(); len(identities) != 1 {
+		t.Error("a loose match should not delete anything")
+	}
+
+	err := handleCLICommand(store, []string{"delete", "nobody"})
+	if !errors.Is(err, errIdentityNotFound) {
+		t.Errorf("delete nobody error = %v, want errIdentityNotFound", err)
+	}
+}
+
+func TestUpdateInStore(t *testing.T) {
+	store := newMemoryStore(
+		Identity{Name: "Work User", Email: "work@example.com", Nickname: "work"},
+		Identity{Name: "OSS User", Email: "oss@example.com"},
+	)
+	work, _ := findIdentity(store, "work")
+
+	work.Email = "new@example.com"
+	if err := updateInStore(store, work); err != nil {
+		t.Fatalf("updateInStore failed: %v", err)
+	}
+	if identity, _, _ := store.Get(work.ID); identity.Email != "new@example.com" {
+		t.Errorf("email after update = %q, want new@example.com", identity.Email)
+	}
+
+	work.Name, work.Email = "OSS User", "oss@example.com"
+	if err := updateInStore(store, work); exitStatus(err) != ExitInvalid {
+		t.Errorf("updating to another identity's name and email returned %v, want a validation error", err)
+	}
+}
+
+func TestMalformedConfigIsReported(t *testing.T) {
+	t.Cleanup(setupTestGitConfig(t))
+	addIdentity("Work User", "work@example.com", "work")
+	f, err := os.OpenFile(filepath.Join(os.Getenv("HOME"), ".gitconfig"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("[identity\n")
+	f.Close()
+
+	store := gitConfigStore{}
+	if identities, err := store.List(); err == nil {
+		t.Errorf("List on a malformed config = %+v, want an error", identities)
+	}
+	if _, err := findIdentity(store, "work"); err == nil || errors.Is(err, errIdentityNotFound) {
+		t.Errorf("findIdentity on a malformed config returned %v, want the read error", err)
+	}
+	if _, err := newIdentityID(); err == nil {
+		t.Error("newIdentityID on a malformed config should fail")
+	}
+	if _, err := legacyIdentities(); err == nil {
+		t.Error("legacyIdentities on a malformed config should fail")
+	}
+}
+
+func TestFindIdentityAmbiguous(t *testing.T) {
+	stdin := os.Stdin
+	os.Stdin, _ = os.Open(os.DevNull)
+	defer func() { os.Stdin = stdin }()
+
+	store := newMemoryStore(
+		Identity{Name: "John Doe", Email: "john@work.com", Nickname: "work"},
+		Identity{Name: "John Smith", Email: "john@oss.org"},
+		Identity{Name: "Johnny Cash", Email: "cash@example.com"},
+		Identity{Name: "J. Bot", Email: "john@oss.org"},
+	)
+
+	tests := []struct {
+		name       string
+		store      IdentityStore
+		identifier string
+		expected   string
+		candidates int
+	}{
+		{"exact name wins over partial ones", store, "John Doe", "john@work.com", 0},
+		{"nickname wins over email substring", store, "work", "john@work.com", 0},
+		{"unique partial match", store, "Cash", "cash@example.com", 0},
+		{"several partial matches", store, "John", "", 3},
+		{"loose match is not acted on", store, "jcsh", "", 1},
+		{"shared email", store, "john@oss.org", "", 2},
+		{"exact only", exactStore{store}, "Johnny Cash", "cash@example.com", 0},
+		{"exact only skips partial matches", exactStore{store}, "Cash", "", 0},
+		{"exact only is case-sensitive", exactStore{store}, "johnny cash", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := findIdentity(tt.store, tt.identifier)
+			var ambiguous *ambiguousError
+			switch {
+			case tt.candidates > 0:
+				if !errors.As(err, &ambiguous) || len(ambiguous.candidates) != tt.candidates {
+					t.Errorf("findIdentity(%q) = %+v, %v; want %d candidates", tt.identifier, identity, err, tt.candidates)
+				}
+			case tt.expected == "":
+				if !errors.Is(err, errIdentityNotFound) {
+					t.Errorf("findIdentity(%q) error = %v, want errIdentityNotFound", tt.identifier, err)
+				}
+			case err != nil || identity.Email != tt.expected:
+				t.Errorf("findIdentity(%q) = %+v, %v; want %s", tt.identifier, identity, err, tt.expected)
+			}
+		})
+	}
+
+	err := handleCLICommand(store, []string{"delete", "John"})
+	if exitStatus(err) != ExitAmbiguous || !strings.Contains(err.Error(), "John Smith <john@oss.org>") {
+		t.Errorf("delete John error = %v, want the candidate list", err)
+	}
+	if identities, _ := store.List(); len(identities) != 4 {
+		t.Error("an ambiguous delete should not remove anything")
+	}
+
+	for _, args := range [][]string{
+		{"--exact", "switch", "John"},
+		{"switch", "--exact", "John"},
+		{"--exact", "--dry-run", "delete", "John"},
+	} {
+		if err := handleCLICommand(store, args); !errors.Is(err, errIdentityNotFound) {
+			t.Errorf("handleCLICommand(%q) error = %v, want errIdentityNotFound", args, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// catalogVersion is the version key written to exported catalogs.
+const catalogVersion = 1
+
+// catalogFields maps the keys of an [[identity]] table to Identity fields.
+var catalogFields = []struct {
+	key   string
+	field func(*Identity) *string
+}{
+	{"name", func(i *Identity) *string { return &i.Name }},
+	{"email", func(i *Identity) *string { return &i.Email }},
+	{"nickname", func(i *Identity) *string { return &i.Nickname }},
+	{"signing_format", func(i *Identity) *string { return &i.SigningFormat }},
+	{"signing_key", func(i *Identity) *string { return &i.SigningKey }},
+	{"ssh_key", func(i *Identity) *string { return &i.SSHKey }},
+	{"tags", func(i *Identity) *string { return &i.Tags }},
+}
+
+// encodeCatalog writes identities as a TOML document with one
+// [[identity]] table each. Empty optional fields are left out.
+func encodeCatalog(identities []Identity) string {
+	var b strings.Builder
+	b.WriteString("# gitid identity catalog. Restore with 'gitid import <file>'.\n")
+	fmt.Fprintf(&b, "version = %d\n", catalogVersion)
+
+	for _, identity := range identities {
+		b.WriteString("\n[[identity]]\n")
+		for _, f := range catalogFields {
+			value := *f.field(&identity)
+			if value == "" && f.key != "name" && f.key != "email" {
+				continue
+			}
+			fmt.Fprintf(&b, "%s = %s\n", f.key, tomlQuote(value))
+		}
+	}
+	return b.String()
+}
+
+// tomlQuote renders s as a TOML basic string.
+func tomlQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// decodeCatalog parses the subset of TOML that encodeCatalog writes:
+// comments, a top-level version key and [[identity]] tables of string
+// keys in basic or literal quotes.
+func decodeCatalog(content string) ([]Identity, error) {
+	var identities []Identity
+	var current *Identity
+	seen := map[string]bool{}
+
+	for n, line := range strings.Split(content, "\n") {
+		lineNo := n + 1
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			header, _, _ := strings.Cut(line, "#")
+			if strings.TrimSpace(header) != "[[identity]]" {
+				return nil, fmt.Errorf("line %d: unsupported table %s", lineNo, strings.TrimSpace(header))
+			}
+			identities = append(identities, Identity{})
+			current = &identities[len(identities)-1]
+			seen = map[string]bool{}
+			continue
+		}
+
+		key, rest, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("line %d: duplicate key %s", lineNo, key)
+		}
+		seen[key] = true
+
+		if current == nil {
+			if key != "version" {
+				return nil, fmt.Errorf("line %d: unexpected key %s outside [[identity]]", lineNo, key)
+			}
+			value, _, _ := strings.Cut(rest, "#")
+			version, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: version must be an integer", lineNo)
+			}
+			if version > catalogVersion {
+				return nil, fmt.Errorf("catalog version %d is newer than this gitid supports (%d); upgrade gitid", version, catalogVersion)
+			}
+			continue
+		}
+
+		value, err := parseTOMLString(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		field := catalogField(current, key)
+		if field == nil {
+			return nil, fmt.Errorf("line %d: unknown identity key %s", lineNo, key)
+		}
+		*field = value
+	}
+
+	for i, identity := range identities {
+		if identity.Name == "" || identity.Email == "" {
+			return nil, fmt.Errorf("identity %d: name and email are required", i+1)
+		}
+	}
+	return identities, nil
+}
+
+func catalogField(identity *Identity, key string) *string {
+	for _, f := range catalogFields {
+		if f.key == key {
+			return f.field(identity)
+		}
+	}
+	return nil
+}
+
+// parseTOMLString parses a basic ("...") or literal ('...') string,
+// optionally followed by a comment.
+func parseTOMLString(s string) (string, error) {
+	if strings.HasPrefix(s, "'") {
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return s[1 : end+1], checkTrailing(s[end+2:])
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return "", fmt.Errorf("expected a quoted string")
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return b.String(), checkTrailing(s[i+1:])
+		case c != '\\':
+			b.WriteByte(c)
+		case i+1 >= len(s):
+			return "", fmt.Errorf("unterminated string")
+		default:
+			i++
+			switch s[i] {
+			case '"', '\\':
+				b.WriteByte(s[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'u', 'U':
+				size := 4
+				if s[i] == 'U' {
+					size = 8
+				}
+				if i+size >= len(s) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += size
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func checkTrailing(s string) error {
+	s = strings.TrimSpace(s)
+	if s != "" && !strings.HasPrefix(s, "#") {
+		return fmt.Errorf("unexpected %q after value", s)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCatalogRoundTrip(t *testing.T) {
+	identities := []Identity{
+		{Name: "Work User", Email: "work@example.com", Nickname: "work", SigningFormat: SigningSSH, SigningKey: "~/.ssh/id_work.pub", SSHKey: "~/.ssh/id_work", Tags: "acme,oncall"},
+		{Name: `Quote "Q" Back\slash`, Email: "odd@example.com", Nickname: "tab\there"},
+		{Name: "Zoë Ünïcode\nNewline", Email: "zoe@example.com", SigningFormat: SigningOpenPGP, SigningKey: "3AA5C34371567BD2"},
+	}
+
+	decoded, err := decodeCatalog(encodeCatalog(identities))
+	if err != nil {
+		t.Fatalf("decodeCatalog failed: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, identities) {
+		t.Errorf("round trip = %+v, want %+v", decoded, identities)
+	}
+}
+
+func TestDecodeCatalog(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []Identity
+		wantErr  bool
+	}{
+		{
+			name: "comments and literal strings",
+			content: `# exported
+version = 1 # current
+
+[[identity]] # first
+name = 'C:\Users\me'
+email = "me@example.com" # trailing
+nickname = "caf\u00e9"
+`,
+			expected: []Identity{{Name: `C:\Users\me`, Email: "me@example.com", Nickname: "café"}},
+		},
+		{name: "empty", content: "version = 1\n", expected: nil},
+		{name: "newer version", content: "version = 2\n", wantErr: true},
+		{name: "missing email", content: "[[identity]]\nname = \"x\"\n", wantErr: true},
+		{name: "unknown key", content: "[[identity]]\nname = \"x\"\nemail = \"x@y\"\ncolour = \"red\"\n", wantErr: true},
+		{name: "duplicate key", content: "[[identity]]\nname = \"x\"\nname = \"y\"\nemail = \"x@y\"\n", wantErr: true},
+		{name: "unsupported table", content: "[identity]\nname = \"x\"\n", wantErr: true},
+		{name: "unquoted value", content: "[[identity]]\nname = x\n", wantErr: true},
+		{name: "unterminated string", content: "[[identity]]\nname = \"x\n", wantErr: true},
+		{name: "bad escape", content: "[[identity]]\nname = \"\\q\"\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identities, err := decodeCatalog(tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decodeCatalog should fail, got %+v", identities)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeCatalog failed: %v", err)
+			}
+			if !reflect.DeepEqual(identities, tt.expected) {
+				t.Errorf("decodeCatalog = %+v, want %+v", identities, tt.expected)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/term"
 	"github.com/posener/complete/v2/install"
 )
 
@@ -18,27 +19,29 @@ var (
 	successColor   = lipgloss.Color("2")
 )
 
-func runTUI() {
+func runTUI(store IdentityStore) {
 	// Check if user has no identities and completion not installed
-	identities := getAllIdentities()
+	identities, _ := store.List()
 	if len(identities) == 0 && shouldPromptForCompletion() {
 		if runCompletionPrompt() {
 			// After completion prompt, continue to main TUI
 		}
 	}
 
-	p := tea.NewProgram(initialModel())
+	p := tea.NewProgram(initialModel(store))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v", err)
 		os.Exit(1)
 	}
 }
 
-func initialModel() Model {
-	identities := getAllIdentities()
+func initialModel(store IdentityStore) Model {
+	identities, _ := store.List()
 	return Model{
+		store:            store,
 		identities:       identities,
 		cursor:           0,
+		target:           globalTarget,
 		showConfirmation: false,
 		confirmChoices:   []string{"Yes", "No"},
 		confirmCursor:    1,
@@ -66,11 +69,11 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "enter":
 			if m.showConfirmation {
 				if m.confirmCursor == 0 {
-					email := m.identities[m.cursor].Email
-					if err := deleteIdentity(email); err != nil {
+					id := m.identities[m.cursor].ID
+					if err := m.store.Delete(id); err != nil {
 						fmt.Printf("Error deleting identity: %v\n", err)
 					} else {
-						m.identities = getAllIdentities()
+						m.identities, _ = m.store.List()
 						if m.cursor >= len(m.identities) {
 							m.cursor = len(m.identities)
 						}
@@ -80,28 +83,36 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.confirmCursor = 1
 			} else {
 				if m.cursor >= len(m.identities) {
-					addIdentityTUI()
-					m.identities = getAllIdentities()
+					addIdentityTUI(m.store)
+					m.identities, _ = m.store.List()
 				} else {
 					identity := m.identities[m.cursor]
-					switchIdentity(identity.Name, identity.Email)
+					if err := m.store.SetActive(identity, m.target); err != nil {
+						m.errMsg = err.Error()
+						return m, nil
+					}
 					return m, tea.Quit
 				}
 			}
+		case "s":
+			if !m.showConfirmation {
+				m.target = nextSwitchTarget(m.target)
+				m.errMsg = ""
+			}
 		case "D":
 			if m.cursor < len(m.identities) {
 				m.showConfirmation = true
 			}
 		case "e":
 			if m.cursor < len(m.identities) {
-				editNicknameTUI(m.identities[m.cursor])
-				m.identities = getAllIdentities()
+				editNicknameTUI(m.store, m.identities[m.cursor])
+				m.identities, _ = m.store.List()
 				return m, tea.ClearScreen
 			}
 		case "E":
 			if m.cursor < len(m.identities) {
-				editFullIdentityTUI(m.identities[m.cursor])
-				m.identities = getAllIdentities()
+				editFullIdentityTUI(m.store, m.identities[m.cursor])
+				m.identities, _ = m.store.List()
 				return m, tea.ClearScreen
 			}
 		case "left", "h":
@@ -128,6 +139,11 @@ func (m Model) View() string {
 		Bold(true).
 		Foreground(highlightColor).
 		Render("Git Identity Manager")
+	if m.target.Scope != ScopeGlobal {
+		title += lipgloss.NewStyle().
+			Foreground(subtleColor).
+			Render(" [" + m.target.String() + "]")
+	}
 
 	var items []string
 	for i, identity := range m.identities {
@@ -182,9 +198,15 @@ func (m Model) View() string {
 		)
 	}
 
+	if m.errMsg != "" {
+		items = append(items, lipgloss.NewStyle().
+			Foreground(errorColor).
+			Render("\n"+m.errMsg))
+	}
+
 	helpStyle := lipgloss.NewStyle().Foreground(subtleColor)
 	help := helpStyle.Render("\n" +
-		"↑/k up • ↓/j down • enter select • D delete • e edit nickname • E edit full • q quit\n" +
+		"↑/k up • ↓/j down • enter select • s scope • D delete • e edit nickname • E edit full • q quit\n" +
 		"Confirmation: ←/→ navigate • enter confirm • esc cancel",
 	)
 
@@ -195,6 +217,18 @@ func (m Model) View() string {
 	)
 }
 
+// switchScopes is the order the TUI cycles through when changing scope.
+var switchScopes = []ConfigScope{ScopeGlobal, ScopeLocal, ScopeWorktree}
+
+func nextSwitchTarget(target ConfigTarget) ConfigTarget {
+	for i, scope := range switchScopes {
+		if scope == target.Scope {
+			return ConfigTarget{Scope: switchScopes[(i+1)%len(switchScopes)]}
+		}
+	}
+	return globalTarget
+}
+
 func shouldPromptForCompletion() bool {
 	// Check if completion is already installed
 	if install.IsInstalled("gitid") {
@@ -311,18 +345,58 @@ func (m CompletionPromptModel) View() string {
 	)
 }
 
-func addIdentityTUI() {
+func addIdentityTUI(store IdentityStore) {
 	name := prompt("Enter name")
 	email := prompt("Enter email")
 	nickname := prompt("Enter nickname (optional)")
+	format, signingKey := promptSigningKey(email)
+	sshKey := prompt("Enter SSH private key for push/fetch (optional)")
+
+	identity := Identity{Name: name, Email: email, Nickname: nickname}
+	if withKey, err := withSigningKey(identity, format, signingKey); err != nil {
+		fmt.Printf("Error setting signing key: %v\n", err)
+	} else {
+		identity = withKey
+	}
+	if withKey, err := withSSHKey(identity, sshKey); err != nil {
+		fmt.Printf("Error setting SSH key: %v\n", err)
+	} else {
+		identity = withKey
+	}
 
-	if err := addIdentity(name, email, nickname); err != nil {
+	if err := addToStore(store, identity); err != nil {
 		fmt.Printf("Error adding identity: %v\n", err)
 	}
 }
 
-func editNicknameTUI(identity Identity) {
-	currentNickname := getNickname(identity.Email)
+// promptSigningKey offers the OpenPGP and X.509 secret keys available for
+// email, plus an SSH key path, and returns the chosen format and key.
+func promptSigningKey(email string) (string, string) {
+	choices := []string{"No signing", "SSH key (enter path)"}
+	type option struct{ format, key string }
+	options := []option{{}, {format: SigningSSH}}
+
+	for _, format := range []string{SigningOpenPGP, SigningX509} {
+		keys, _ := usableSecretKeys(format, email)
+		for _, key := range keys {
+			choices = append(choices, fmt.Sprintf("%s %s", format, key))
+			options = append(options, option{format: format, key: key.Fingerprint})
+		}
+	}
+
+	choice, ok := pick("Sign commits for "+email+"?", choices)
+	if !ok {
+		return "", ""
+	}
+	selected := options[choice]
+	if selected.format == SigningSSH {
+		selected.key = prompt("Enter SSH signing key path")
+	}
+	return selected.format, selected.key
+}
+
+func editNicknameTUI(store IdentityStore, identity Identity) {
+	currentNickname := identity.Nickname
 	if currentNickname == "" {
 		currentNickname = "(none)"
 	}
@@ -330,12 +404,13 @@ func editNicknameTUI(identity Identity) {
 	fmt.Printf("Current nickname for %s: %s\n", identity.Name, currentNickname)
 	newNickname := prompt("Enter new nickname (leave empty to remove)")
 
-	if err := setNickname(identity.Email, newNickname); err != nil {
+	identity.Nickname = newNickname
+	if err := store.Put(identity); err != nil {
 		fmt.Printf("Error setting nickname: %v\n", err)
 	}
 }
 
-func editFullIdentityTUI(identity Identity) {
+func editFullIdentityTUI(store IdentityStore, identity Identity) {
 	fmt.Printf("Editing identity: %s\n", getIdentityDisplay(identity))
 
 	newName := prompt("Enter name (" + identity.Name + ")")
@@ -348,7 +423,7 @@ func editFullIdentityTUI(identity Identity) {
 		newEmail = identity.Email
 	}
 
-	currentNickname := getNickname(identity.Email)
+	currentNickname := identity.Nickname
 	nicknamePrompt := "Enter nickname"
 	if currentNickname != "" {
 		nicknamePrompt += " (" + currentNickname + ")"
@@ -359,7 +434,8 @@ func editFullIdentityTUI(identity Identity) {
 		newNickname = currentNickname
 	}
 
-	if err := updateIdentity(identity.Email, newName, newEmail, newNickname); err != nil {
+	identity.Name, identity.Email, identity.Nickname = newName, newEmail, newNickname
+	if err := updateInStore(store, identity); err != nil {
 		fmt.Printf("Error updating identity: %v\n", err)
 	}
 }
@@ -415,3 +491,194 @@ func (m InputModel) View() string {
 		Margin(0, 1).
 		Render(m.textInput.View())
 }
+
+// isInteractive reports whether gitid runs on a terminal and may ask the
+// user instead of failing.
+func isInteractive() bool {
+	return term.IsTerminal(os.Stdin.Fd()) && term.IsTerminal(os.Stdout.Fd())
+}
+
+// pickIdentity lets the user search identities, starting from query, and
+// pick one. It reports false when the user cancels.
+func pickIdentity(title, query string, identities []Identity) (Identity, bool, error) {
+	p := tea.NewProgram(newIdentityPicker(title, query, identities))
+	m, err := p.Run()
+	if err != nil {
+		return Identity{}, false, fmt.Errorf("error running picker: %w", err)
+	}
+
+	result := m.(IdentityPickerModel)
+	if !result.chosen {
+		return Identity{}, false, nil
+	}
+	return result.ranked[result.cursor].Identity, true, nil
+}
+
+// identityPickerRows is how many matches the picker shows at once.
+const identityPickerRows = 10
+
+func newIdentityPicker(title, query string, identities []Identity) IdentityPickerModel {
+	filter := textinput.New()
+	filter.Placeholder = "Type to search nickname, name, email or tags"
+	filter.SetValue(query)
+	filter.Focus()
+	return IdentityPickerModel{
+		title:      title,
+		filter:     filter,
+		identities: identities,
+		ranked:     rankIdentities(identities, query),
+	}
+}
+
+func (m IdentityPickerModel) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (m IdentityPickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			m.cancelled = true
+			return m, tea.Quit
+		case "up", "ctrl+p":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+			return m, nil
+		case "down", "ctrl+n":
+			if m.cursor < min(len(m.ranked), identityPickerRows)-1 {
+				m.cursor++
+			}
+			return m, nil
+		case "enter":
+			if len(m.ranked) > 0 {
+				m.chosen = true
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	query := m.filter.Value()
+	m.filter, cmd = m.filter.Update(msg)
+	if m.filter.Value() != query {
+		m.ranked = rankIdentities(m.identities, m.filter.Value())
+		m.cursor = 0
+	}
+	return m, cmd
+}
+
+func (m IdentityPickerModel) View() string {
+	if m.chosen || m.cancelled {
+		return ""
+	}
+
+	title := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(highlightColor).
+		Render(m.title)
+
+	var items []string
+	for i, candidate := range m.ranked[:min(len(m.ranked), identityPickerRows)] {
+		cursor := "  "
+		choice := getIdentityDisplay(candidate.Identity)
+		if m.cursor == i {
+			cursor = "▸ "
+			choice = lipgloss.NewStyle().
+				Foreground(highlightColor).
+				Bold(true).
+				Render(choice)
+		}
+		items = append(items, cursor+choice)
+	}
+	if len(items) == 0 {
+		items = append(items, lipgloss.NewStyle().Foreground(subtleColor).Render("  No matching identities"))
+	}
+
+	help := lipgloss.NewStyle().
+		Foreground(subtleColor).
+		Render("\n↑ up • ↓ down • enter select • esc cancel")
+
+	return lipgloss.NewStyle().Margin(0, 1).Render(
+		title + "\n\n" +
+			m.filter.View() + "\n\n" +
+			strings.Join(items, "\n") +
+			help,
+	)
+}
+
+// pick shows choices in a list and returns the selected index. It reports
+// false when the user cancels.
+func pick(title string, choices []string) (int, bool) {
+	p := tea.NewProgram(PickerModel{title: title, choices: choices})
+	m, err := p.Run()
+	if err != nil {
+		fmt.Printf("Error running picker: %v\n", err)
+		os.Exit(1)
+	}
+
+	result := m.(PickerModel)
+	return result.cursor, result.chosen
+}
+
+func (m PickerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m PickerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q", "esc":
+			m.cancelled = true
+			return m, tea.Quit
+		case "up", "k":
+			if m.cursor > 0 {
+				m.cursor--
+			}
+		case "down", "j":
+			if m.cursor < len(m.choices)-1 {
+				m.cursor++
+			}
+		case "enter":
+			m.chosen = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m PickerModel) View() string {
+	if m.chosen || m.cancelled {
+		return ""
+	}
+
+	title := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(highlightColor).
+		Render(m.title)
+
+	var items []string
+	for i, choice := range m.choices {
+		cursor := "  "
+		if m.cursor == i {
+			cursor = "▸ "
+			choice = lipgloss.NewStyle().
+				Foreground(highlightColor).
+				Bold(true).
+				Render(choice)
+		}
+		items = append(items, cursor+choice)
+	}
+
+	help := lipgloss.NewStyle().
+		Foreground(subtleColor).
+		Render("\n↑/k up • ↓/j down • enter select • esc cancel")
+
+	return lipgloss.NewStyle().Margin(0, 1).Render(
+		title + "\n\n" +
+			strings.Join(items, "\n") +
+			help,
+	)
+}
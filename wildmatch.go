@@ -0,0 +1,47 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// wildmatch reports whether s matches the glob pattern using the subset of
+// git's wildmatch rules that includeIf conditions rely on: "*" and "?" stay
+// within a path segment, "**" crosses segments, and a trailing "/" matches
+// everything below it.
+func wildmatch(pattern, s string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	runes := []rune(pattern)
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					i++
+					expr.WriteString("(?:.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}